@@ -5,14 +5,25 @@ type Expression interface {
 }
 
 type Visitor interface {
-	VisitBinaryExpression(expression Binary) interface{}
-	VisitGroupingExpression(expression Grouping) interface{}
-	VisitLiteralExpression(expression Literal) interface{}
-	VisitUnaryExpression(expression Unary) interface{}
-	VisitVariableExpression(expression Variable) interface{}
-	VisitAssignExpression(expression Assign) interface{}
-	VisitLogicalExpression(expression Logical) interface{}
-	VisitCallExpression(expression Call) interface{}
+	VisitBinaryExpression(expression *Binary) interface{}
+	VisitGroupingExpression(expression *Grouping) interface{}
+	VisitLiteralExpression(expression *Literal) interface{}
+	VisitUnaryExpression(expression *Unary) interface{}
+	VisitVariableExpression(expression *Variable) interface{}
+	VisitAssignExpression(expression *Assign) interface{}
+	VisitLogicalExpression(expression *Logical) interface{}
+	VisitCallExpression(expression *Call) interface{}
+	VisitGetExpression(expression *Get) interface{}
+	VisitSetExpression(expression *Set) interface{}
+	VisitThisExpression(expression *This) interface{}
+	VisitSuperExpression(expression *Super) interface{}
+	VisitListLiteralExpression(expression *ListLiteral) interface{}
+	VisitIndexExpression(expression *Index) interface{}
+	VisitIndexSetExpression(expression *IndexSet) interface{}
+	VisitSliceExpression(expression *Slice) interface{}
+	VisitMapLiteralExpression(expression *MapLiteral) interface{}
+	VisitLambdaExpression(expression *Lambda) interface{}
+	VisitInterpolationExpression(expression *Interpolation) interface{}
 }
 
 type Binary struct {
@@ -21,7 +32,7 @@ type Binary struct {
 	Right    Expression
 }
 
-func (b Binary) Accept(visitor Visitor) interface{} {
+func (b *Binary) Accept(visitor Visitor) interface{} {
 	return visitor.VisitBinaryExpression(b)
 }
 
@@ -29,7 +40,7 @@ type Grouping struct {
 	Expression Expression
 }
 
-func (g Grouping) Accept(visitor Visitor) interface{} {
+func (g *Grouping) Accept(visitor Visitor) interface{} {
 	return visitor.VisitGroupingExpression(g)
 }
 
@@ -37,7 +48,7 @@ type Literal struct {
 	Value any
 }
 
-func (l Literal) Accept(visitor Visitor) interface{} {
+func (l *Literal) Accept(visitor Visitor) interface{} {
 	return visitor.VisitLiteralExpression(l)
 }
 
@@ -46,24 +57,34 @@ type Unary struct {
 	Right    Expression
 }
 
-func (u Unary) Accept(visitor Visitor) interface{} {
+func (u *Unary) Accept(visitor Visitor) interface{} {
 	return visitor.VisitUnaryExpression(u)
 }
 
+// Binding is where the resolver found the variable an expression refers to. A local lives Depth environments up from
+// where it's used, at Slot in that environment. Anything that isn't Local is a global, which is looked up by name.
+type Binding struct {
+	Local bool
+	Depth int
+	Slot  int
+}
+
 type Variable struct {
-	Name Token
+	Name    Token
+	Binding Binding
 }
 
-func (v Variable) Accept(visitor Visitor) interface{} {
+func (v *Variable) Accept(visitor Visitor) interface{} {
 	return visitor.VisitVariableExpression(v)
 }
 
 type Assign struct {
-	Name  Token
-	Value Expression
+	Name    Token
+	Value   Expression
+	Binding Binding
 }
 
-func (a Assign) Accept(visitor Visitor) interface{} {
+func (a *Assign) Accept(visitor Visitor) interface{} {
 	return visitor.VisitAssignExpression(a)
 }
 
@@ -73,7 +94,7 @@ type Logical struct {
 	Right    Expression
 }
 
-func (l Logical) Accept(visitor Visitor) interface{} {
+func (l *Logical) Accept(visitor Visitor) interface{} {
 	return visitor.VisitLogicalExpression(l)
 }
 
@@ -83,6 +104,118 @@ type Call struct {
 	Arguments []Expression
 }
 
-func (c Call) Accept(visitor Visitor) interface{} {
+func (c *Call) Accept(visitor Visitor) interface{} {
 	return visitor.VisitCallExpression(c)
 }
+
+type Get struct {
+	Object Expression
+	Name   Token
+}
+
+func (g *Get) Accept(visitor Visitor) interface{} {
+	return visitor.VisitGetExpression(g)
+}
+
+type Set struct {
+	Object Expression
+	Name   Token
+	Value  Expression
+}
+
+func (s *Set) Accept(visitor Visitor) interface{} {
+	return visitor.VisitSetExpression(s)
+}
+
+type This struct {
+	Keyword Token
+	Binding Binding
+}
+
+func (t *This) Accept(visitor Visitor) interface{} {
+	return visitor.VisitThisExpression(t)
+}
+
+type Super struct {
+	Keyword Token
+	Method  Token
+	Binding Binding
+}
+
+func (s *Super) Accept(visitor Visitor) interface{} {
+	return visitor.VisitSuperExpression(s)
+}
+
+type ListLiteral struct {
+	Bracket  Token
+	Elements []Expression
+}
+
+func (ll *ListLiteral) Accept(visitor Visitor) interface{} {
+	return visitor.VisitListLiteralExpression(ll)
+}
+
+type Index struct {
+	Object  Expression
+	Bracket Token
+	Index   Expression
+}
+
+func (i *Index) Accept(visitor Visitor) interface{} {
+	return visitor.VisitIndexExpression(i)
+}
+
+type IndexSet struct {
+	Object  Expression
+	Bracket Token
+	Index   Expression
+	Value   Expression
+}
+
+func (is *IndexSet) Accept(visitor Visitor) interface{} {
+	return visitor.VisitIndexSetExpression(is)
+}
+
+// Slice is object[start:end], where either bound may be left out (and is then nil).
+type Slice struct {
+	Object  Expression
+	Bracket Token
+	Start   Expression
+	End     Expression
+}
+
+func (s *Slice) Accept(visitor Visitor) interface{} {
+	return visitor.VisitSliceExpression(s)
+}
+
+// MapLiteral holds its entries as two parallel slices, in the order they were written.
+type MapLiteral struct {
+	Brace  Token
+	Keys   []Expression
+	Values []Expression
+}
+
+func (ml *MapLiteral) Accept(visitor Visitor) interface{} {
+	return visitor.VisitMapLiteralExpression(ml)
+}
+
+// Lambda is an anonymous function, written either as function (params) { ... } or (params) => ... The declaration
+// is named "anonymous" so it can be called and printed like any other function.
+type Lambda struct {
+	Declaration *FunctionStatement
+}
+
+func (l *Lambda) Accept(visitor Visitor) interface{} {
+	return visitor.VisitLambdaExpression(l)
+}
+
+// Interpolation is a string like "a ${b} c". Parts alternates between string literals and the embedded expressions,
+// whose values are stringified and joined together.
+type Interpolation struct {
+	Quote Token
+	Parts []Expression
+}
+
+func (i *Interpolation) Accept(visitor Visitor) interface{} {
+	return visitor.VisitInterpolationExpression(i)
+}
@@ -5,21 +5,28 @@ type Statement interface {
 }
 
 type StatementVisitor interface {
-	VisitExpressionStatement(statement ExpressionStatement) interface{}
-	VisitPrintStatement(statement PrintStatement) interface{}
-	VisitVariableStatement(statement VariableStatement) interface{}
-	VisitBlockStatement(statement BlockStatement) interface{}
-	VisitIfStatement(statement IfStatement) interface{}
-	VisitWhileStatement(statement WhileStatement) interface{}
-	VisitFunctionStatement(statement FunctionStatement) interface{}
-	VisitReturnStatement(statement ReturnStatement) interface{}
+	VisitExpressionStatement(statement *ExpressionStatement) interface{}
+	VisitPrintStatement(statement *PrintStatement) interface{}
+	VisitVariableStatement(statement *VariableStatement) interface{}
+	VisitBlockStatement(statement *BlockStatement) interface{}
+	VisitIfStatement(statement *IfStatement) interface{}
+	VisitWhileStatement(statement *WhileStatement) interface{}
+	VisitFunctionStatement(statement *FunctionStatement) interface{}
+	VisitReturnStatement(statement *ReturnStatement) interface{}
+	VisitClassStatement(statement *ClassStatement) interface{}
+	VisitForInStatement(statement *ForInStatement) interface{}
+	VisitBreakStatement(statement *BreakStatement) interface{}
+	VisitContinueStatement(statement *ContinueStatement) interface{}
+	VisitImportStatement(statement *ImportStatement) interface{}
+	VisitThrowStatement(statement *ThrowStatement) interface{}
+	VisitTryStatement(statement *TryStatement) interface{}
 }
 
 type ExpressionStatement struct {
 	Expression Expression
 }
 
-func (es ExpressionStatement) Accept(visitor StatementVisitor) interface{} {
+func (es *ExpressionStatement) Accept(visitor StatementVisitor) interface{} {
 	return visitor.VisitExpressionStatement(es)
 }
 
@@ -27,7 +34,7 @@ type PrintStatement struct {
 	Expression Expression
 }
 
-func (ps PrintStatement) Accept(visitor StatementVisitor) interface{} {
+func (ps *PrintStatement) Accept(visitor StatementVisitor) interface{} {
 	return visitor.VisitPrintStatement(ps)
 }
 
@@ -36,15 +43,18 @@ type VariableStatement struct {
 	Initializer Expression
 }
 
-func (vs VariableStatement) Accept(visitor StatementVisitor) interface{} {
+func (vs *VariableStatement) Accept(visitor StatementVisitor) interface{} {
 	return visitor.VisitVariableStatement(vs)
 }
 
 type BlockStatement struct {
 	Statements []Statement
+	// Size is how many variables the block declares, as counted by the resolver. A block that declares none doesn't
+	// get an environment of its own.
+	Size int
 }
 
-func (bs BlockStatement) Accept(visitor StatementVisitor) interface{} {
+func (bs *BlockStatement) Accept(visitor StatementVisitor) interface{} {
 	return visitor.VisitBlockStatement(bs)
 }
 
@@ -54,16 +64,22 @@ type IfStatement struct {
 	ElseBranch Statement
 }
 
-func (is IfStatement) Accept(visitor StatementVisitor) interface{} {
+func (is *IfStatement) Accept(visitor StatementVisitor) interface{} {
 	return visitor.VisitIfStatement(is)
 }
 
+// WhileStatement also backs C-style for loops, whose increment runs after every iteration (even after a continue).
+// Label is nil unless the loop was written as 'name: while (...)'.
 type WhileStatement struct {
+	// Keyword is the 'while' or 'for' the loop was written with
+	Keyword   Token
 	Condition Expression
 	Body      Statement
+	Increment Expression
+	Label     *Token
 }
 
-func (ws WhileStatement) Accept(visitor StatementVisitor) interface{} {
+func (ws *WhileStatement) Accept(visitor StatementVisitor) interface{} {
 	return visitor.VisitWhileStatement(ws)
 }
 
@@ -71,9 +87,11 @@ type FunctionStatement struct {
 	Name   Token
 	Params []Token
 	Body   []Statement
+	// Size is how many variables the function declares, parameters included, as counted by the resolver
+	Size int
 }
 
-func (fs FunctionStatement) Accept(visitor StatementVisitor) interface{} {
+func (fs *FunctionStatement) Accept(visitor StatementVisitor) interface{} {
 	return visitor.VisitFunctionStatement(fs)
 }
 
@@ -82,6 +100,84 @@ type ReturnStatement struct {
 	Value   Expression
 }
 
-func (rs ReturnStatement) Accept(visitor StatementVisitor) interface{} {
+func (rs *ReturnStatement) Accept(visitor StatementVisitor) interface{} {
 	return visitor.VisitReturnStatement(rs)
 }
+
+type ClassStatement struct {
+	Name       Token
+	Superclass *Variable
+	Methods    []*FunctionStatement
+}
+
+func (cs *ClassStatement) Accept(visitor StatementVisitor) interface{} {
+	return visitor.VisitClassStatement(cs)
+}
+
+// ForInStatement is for (value in iterable) or for (key, value in iterable), so Variables holds one or two names.
+type ForInStatement struct {
+	Variables []Token
+	In        Token
+	Iterable  Expression
+	Body      Statement
+	Label     *Token
+}
+
+func (fis *ForInStatement) Accept(visitor StatementVisitor) interface{} {
+	return visitor.VisitForInStatement(fis)
+}
+
+// BreakStatement and ContinueStatement have a nil Label when they target the innermost loop.
+type BreakStatement struct {
+	Keyword Token
+	Label   *Token
+}
+
+func (bs *BreakStatement) Accept(visitor StatementVisitor) interface{} {
+	return visitor.VisitBreakStatement(bs)
+}
+
+type ContinueStatement struct {
+	Keyword Token
+	Label   *Token
+}
+
+func (cs *ContinueStatement) Accept(visitor StatementVisitor) interface{} {
+	return visitor.VisitContinueStatement(cs)
+}
+
+// ImportStatement is either import "path" as Alias; (which binds the whole module) or from "path" import Names;
+// (which binds single exports). Exactly one of Alias and Names is set.
+type ImportStatement struct {
+	Keyword Token
+	Path    Token
+	Alias   *Token
+	Names   []Token
+}
+
+func (is *ImportStatement) Accept(visitor StatementVisitor) interface{} {
+	return visitor.VisitImportStatement(is)
+}
+
+type ThrowStatement struct {
+	Keyword Token
+	Value   Expression
+}
+
+func (ts *ThrowStatement) Accept(visitor StatementVisitor) interface{} {
+	return visitor.VisitThrowStatement(ts)
+}
+
+// TryStatement has a nil Catch or Finally when that clause was left out (but never both), and a nil CatchName
+// when the catch clause doesn't bind the error to a variable.
+type TryStatement struct {
+	Keyword   Token
+	Body      *BlockStatement
+	CatchName *Token
+	Catch     *BlockStatement
+	Finally   *BlockStatement
+}
+
+func (ts *TryStatement) Accept(visitor StatementVisitor) interface{} {
+	return visitor.VisitTryStatement(ts)
+}
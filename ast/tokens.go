@@ -12,7 +12,10 @@ const (
 	RIGHT_BRACKET
 	LEFT_BRACE
 	RIGHT_BRACE
+	LEFT_SQUARE_BRACKET
+	RIGHT_SQUARE_BRACKET
 	COMMA
+	COLON
 	DOT
 	MINUS
 	PLUS
@@ -33,30 +36,46 @@ const (
 	LESS_EQUAL
 	INCREMENT
 	DECREMENT
+	ARROW
 
 	// Literals
 	IDENTIFIER
 	STRING
+	// INTERPOLATION is the text of a string up to a "${", which is followed by the tokens of the embedded expression
+	INTERPOLATION
 	NUMBER
 
 	// Keywords
 	AND
+	AS
+	BREAK
+	CATCH
 	CLASS
+	CONTINUE
 	ELSE
 	FALSE
+	FINALLY
+	FROM
 	FUNCTION
 	FOR
 	IF
+	IMPORT
+	IN
 	NIL
 	OR
 	PRINT
 	RETURN
 	SUPER
 	THIS
+	THROW
 	TRUE
+	TRY
 	VARIABLE
 	WHILE
 
+	// ILLEGAL covers text the scanner couldn't make sense of. It has already been reported, so the parser skips past it
+	// quietly
+	ILLEGAL
 	EOF
 )
 
@@ -65,6 +84,61 @@ type Token struct {
 	Lexeme  string
 	Literal any
 	Line    int
+	// Column counts characters from 1, while Offset counts bytes from the start of the source
+	Column int
+	Offset int
+	Source *Source
+}
+
+// Source is a piece of code being run: a whole file, or a line typed into the REPL (which has no Name).
+type Source struct {
+	Name string
+	Text string
+}
+
+// Span is the stretch of source code a diagnostic points at. Length is in bytes, and may be 0 to point between two
+// characters.
+type Span struct {
+	Source *Source
+	Line   int
+	Column int
+	Offset int
+	Length int
+}
+
+// End is the line and column just past the last character of the span.
+func (s Span) End() (line, column int) {
+	line, column = s.Line, s.Column
+	if s.Source == nil || s.Offset+s.Length > len(s.Source.Text) {
+		return line, column
+	}
+	for _, char := range s.Source.Text[s.Offset : s.Offset+s.Length] {
+		if char == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+	return line, column
+}
+
+func (t Token) Span() Span {
+	return Span{Source: t.Source, Line: t.Line, Column: t.Column, Offset: t.Offset, Length: len(t.Lexeme)}
+}
+
+// SpanAfter is the empty span right after the token, for pointing at something that should have followed it.
+func (t Token) SpanAfter() Span {
+	span := Span{Source: t.Source, Line: t.Line, Column: t.Column, Offset: t.Offset + len(t.Lexeme)}
+	for _, char := range t.Lexeme {
+		if char == '\n' {
+			span.Line++
+			span.Column = 1
+		} else {
+			span.Column++
+		}
+	}
+	return span
 }
 
 func (t Token) String() string {
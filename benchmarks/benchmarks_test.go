@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"io"
+	"jota/embed"
+	"os"
+	"testing"
+)
+
+// These time the engines on their own, through embed, without starting a process for every run like the command
+// does: go test -bench . ./benchmarks
+
+func BenchmarkFibonacci(b *testing.B) {
+	benchmarkScript(b, "../examples/fibonacci.jota")
+}
+
+func BenchmarkLoops(b *testing.B) {
+	benchmarkScript(b, "loops.jota")
+}
+
+func BenchmarkClosures(b *testing.B) {
+	benchmarkScript(b, "closures.jota")
+}
+
+func BenchmarkClasses(b *testing.B) {
+	benchmarkScript(b, "classes.jota")
+}
+
+// benchmarkScript runs the script at path on each engine, in a fresh runtime every time.
+func benchmarkScript(b *testing.B, path string) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, engine := range []string{"tree", "vm"} {
+		b.Run(engine, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				// fibonacci.jota times itself with clock()
+				runtime, err := embed.New(embed.Options{Engine: engine, Stdout: io.Discard, Capabilities: embed.Capabilities{Clock: true}})
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := runtime.Eval(context.Background(), string(source)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
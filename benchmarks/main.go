@@ -0,0 +1,109 @@
+// Command benchmarks times how long a jota binary takes to run a set of scripts on each engine, and how much faster it
+// is than another (baseline) build when one is given. Each script is run a few times and the fastest run counts, so
+// noise from the rest of the machine mostly drops out. Starting the process is part of every timing; the Go
+// benchmarks next to this (go test -bench . ./benchmarks) time the engines on their own.
+//
+// Usage, from the repository root:
+//
+//	go run ./benchmarks [-jota ./jota] [-baseline path/to/old/jota] [-engines tree,vm] [-runs 5] [scripts]
+//
+// Without scripts it runs examples/fibonacci.jota and every script in the benchmarks directory. `make bench` builds
+// the binaries and runs this, against a git revision if BASELINE is set. A baseline from before a feature a script
+// uses shows "-" for it, and one from before the VM needs -engines tree.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	jota     = flag.String("jota", "./jota", "the jota binary to time")
+	baseline = flag.String("baseline", "", "another jota binary to compare against, e.g. one built from an older revision")
+	engines  = flag.String("engines", "tree,vm", "the engines to time, separated by commas")
+	runs     = flag.Int("runs", 5, "how many times to run each script, keeping the fastest")
+)
+
+func main() {
+	flag.Parse()
+
+	scripts := flag.Args()
+	if len(scripts) == 0 {
+		matches, _ := filepath.Glob(filepath.Join("benchmarks", "*.jota"))
+		scripts = append([]string{filepath.Join("examples", "fibonacci.jota")}, matches...)
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if *baseline == "" {
+		fmt.Fprintln(table, "script\tengine\ttime")
+	} else {
+		fmt.Fprintln(table, "script\tengine\ttime\tbaseline\tspeedup")
+	}
+
+	for _, script := range scripts {
+		for _, engine := range strings.Split(*engines, ",") {
+			elapsed, err := fastest(*jota, engine, script)
+			if err != nil {
+				fail(err)
+			}
+			if *baseline == "" {
+				fmt.Fprintf(table, "%s\t%s\t%s\n", script, engine, milliseconds(elapsed))
+				continue
+			}
+
+			before, err := fastest(*baseline, engine, script)
+			if err != nil {
+				fmt.Fprintf(table, "%s\t%s\t%s\t-\t-\n", script, engine, milliseconds(elapsed))
+				continue
+			}
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%.2fx\n", script, engine, milliseconds(elapsed), milliseconds(before), before.Seconds()/elapsed.Seconds())
+		}
+	}
+	table.Flush()
+}
+
+// fastest runs script with binary on engine the requested number of times and returns its best time. Whatever the
+// script prints is thrown away, but it has to succeed for the timing to mean anything. Old builds exit with 0 even
+// when the script has an error, so anything on stderr counts as failing too.
+func fastest(binary, engine, script string) (time.Duration, error) {
+	var best time.Duration
+	for run := 0; run < *runs; run++ {
+		// The tree engine is the default, even in builds from before there was a choice
+		command := exec.Command(binary, script)
+		if engine != "tree" {
+			command = exec.Command(binary, "--engine="+engine, script)
+		}
+		var stderr bytes.Buffer
+		command.Stdout, command.Stderr = io.Discard, &stderr
+
+		start := time.Now()
+		err := command.Run()
+		if err == nil && stderr.Len() > 0 {
+			err = fmt.Errorf("%s", strings.TrimSpace(stderr.String()))
+		}
+		if err != nil {
+			return 0, fmt.Errorf("%s --engine=%s %s: %w", binary, engine, script, err)
+		}
+		if elapsed := time.Since(start); run == 0 || elapsed < best {
+			best = elapsed
+		}
+	}
+	return best, nil
+}
+
+func milliseconds(duration time.Duration) string {
+	return fmt.Sprintf("%.1fms", float64(duration.Microseconds())/1000)
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
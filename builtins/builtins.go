@@ -0,0 +1,234 @@
+// Package builtins holds the values and native functions that behave the same no matter which engine runs the
+// script, so the tree-walking interpreter and the VM can't drift apart.
+package builtins
+
+import (
+	"fmt"
+	"jota/errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error is raised (with panic) by natives and value operations. They don't know where in the script they were
+// called from, so each engine turns it into a RuntimeError pointing at the call site.
+type Error struct {
+	Code    errors.Code
+	Message string
+}
+
+// VARIADIC as an arity means the native accepts any number of arguments and checks them itself.
+const VARIADIC = -1
+
+// Engine is what natives can ask of the engine (interpreter or VM) running them.
+type Engine interface {
+	// Call calls a script function, class or native with the given arguments and returns its result.
+	Call(callee any, arguments []any) any
+	// Allocate counts bytes a native is about to allocate against the memory limit, stopping the script if it's over.
+	Allocate(bytes int)
+}
+
+type Native struct {
+	Name        string
+	ArityNumber int
+	NativeLogic func(engine Engine, arguments []any) any
+	// Needs is the capability the native can't work without, if any
+	Needs Capability
+}
+
+func (n *Native) String() string {
+	return "<native fn>"
+}
+
+var Natives = []Native{
+	{Name: "clock", ArityNumber: 0, NativeLogic: clock, Needs: CLOCK},
+	{Name: "milliseconds", ArityNumber: 1, NativeLogic: milliseconds},
+	{Name: "stringify", ArityNumber: 1, NativeLogic: stringify},
+	{Name: "type", ArityNumber: 1, NativeLogic: typeOf},
+	{Name: "len", ArityNumber: 1, NativeLogic: length},
+	{Name: "push", ArityNumber: 2, NativeLogic: push},
+	{Name: "pop", ArityNumber: 1, NativeLogic: pop},
+	{Name: "insert", ArityNumber: 3, NativeLogic: insert},
+	{Name: "remove", ArityNumber: 2, NativeLogic: remove},
+	{Name: "keys", ArityNumber: 1, NativeLogic: keys},
+	{Name: "values", ArityNumber: 1, NativeLogic: values},
+	{Name: "has", ArityNumber: 2, NativeLogic: has},
+	{Name: "delete", ArityNumber: 2, NativeLogic: deleteKey},
+	{Name: "range", ArityNumber: VARIADIC, NativeLogic: makeRange},
+	{Name: "map", ArityNumber: 2, NativeLogic: mapList},
+	{Name: "filter", ArityNumber: 2, NativeLogic: filter},
+	{Name: "sort", ArityNumber: VARIADIC, NativeLogic: sortList},
+}
+
+func clock(engine Engine, arguments []any) any {
+	return float64(time.Now().UnixNano()) / 1e9 // Returns the elapsed time in seconds.
+}
+
+func milliseconds(engine Engine, arguments []any) any {
+	value, ok := arguments[0].(float64)
+	if !ok {
+		return nil
+	}
+	return float64(math.Round(value*1000*100) / 100)
+}
+
+func stringify(engine Engine, arguments []any) any {
+	text := fmt.Sprint(arguments[0])
+	engine.Allocate(len(text))
+	return text
+}
+
+// Typed is implemented by the values each engine makes in its own way (functions, classes and instances), so type()
+// names them the same on both.
+type Typed interface {
+	TypeName() string
+}
+
+func typeOf(engine Engine, arguments []any) any {
+	switch value := arguments[0].(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case *List:
+		return "list"
+	case *Map:
+		return "map"
+	case Range:
+		return "range"
+	case *Module:
+		return "module"
+	case *Exception:
+		return "error"
+	case *Native:
+		return "function"
+	case Typed:
+		return value.TypeName()
+	default:
+		return "unknown"
+	}
+}
+
+// Stringify formats a value the way 'print' shows it.
+func Stringify(value any) string {
+	return stringifyValue(value, make(map[any]bool))
+}
+
+func stringifyValue(value any, seen map[any]bool) string {
+	switch value := value.(type) {
+	case nil:
+		return "nil"
+	case float64:
+		text := fmt.Sprint(value)
+		if strings.HasSuffix(text, ".0") {
+			text = text[0 : len(text)-2]
+		}
+		return text
+	case *List:
+		// A list can contain itself, which would otherwise never finish printing
+		if seen[value] {
+			return "[...]"
+		}
+		seen[value] = true
+		defer delete(seen, value)
+
+		parts := make([]string, len(value.Elements))
+		for i, element := range value.Elements {
+			parts[i] = stringifyElement(element, seen)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	case *Map:
+		if seen[value] {
+			return "{...}"
+		}
+		seen[value] = true
+		defer delete(seen, value)
+
+		parts := make([]string, len(value.keys))
+		for i, key := range value.keys {
+			parts[i] = stringifyElement(key, seen) + ": " + stringifyElement(value.values[key], seen)
+		}
+		return "{" + strings.Join(parts, ", ") + "}"
+	}
+
+	return fmt.Sprint(value)
+}
+
+// Strings inside collections are quoted so that ["1"] and [1] can be told apart.
+func stringifyElement(value any, seen map[any]bool) string {
+	if text, ok := value.(string); ok {
+		return strconv.Quote(text)
+	}
+	return stringifyValue(value, seen)
+}
+
+// Equal is the '==' operator. Lists and maps are compared by their contents, everything else by value (or by
+// identity, for things like functions and instances).
+func Equal(a, b any) bool {
+	return equal(a, b, make(map[[2]any]bool))
+}
+
+func equal(a, b any, comparing map[[2]any]bool) bool {
+	if a == nil && b == nil {
+		return true
+	}
+
+	if a == nil || b == nil {
+		return false
+	}
+
+	switch a := a.(type) {
+	case *List:
+		b, ok := b.(*List)
+		if !ok || len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		// Containers that (indirectly) hold themselves are assumed equal while they're still being compared
+		if a == b || comparing[[2]any{a, b}] {
+			return true
+		}
+		comparing[[2]any{a, b}] = true
+
+		for i := range a.Elements {
+			if !equal(a.Elements[i], b.Elements[i], comparing) {
+				return false
+			}
+		}
+		return true
+	case *Map:
+		b, ok := b.(*Map)
+		if !ok || a.Len() != b.Len() {
+			return false
+		}
+		if a == b || comparing[[2]any{a, b}] {
+			return true
+		}
+		comparing[[2]any{a, b}] = true
+
+		for _, key := range a.keys {
+			other, ok := b.values[key]
+			if !ok || !equal(a.values[key], other, comparing) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return a == b
+}
+
+// IsTruthy decides what counts as true in conditions: everything except nil and false.
+func IsTruthy(value any) bool {
+	if value == nil {
+		return false
+	}
+	if boolean, ok := value.(bool); ok {
+		return boolean
+	}
+	return true
+}
@@ -0,0 +1,128 @@
+package builtins
+
+import (
+	"jota/errors"
+	"path/filepath"
+	"strings"
+)
+
+// Capability is a kind of access to the world outside the script. Natives that need one say so, and only work if the
+// host granted it.
+type Capability string
+
+const (
+	FS_READ       Capability = "fs-read"
+	FS_WRITE      Capability = "fs-write"
+	EXEC          Capability = "exec"
+	ENV           Capability = "env"
+	NET_LOCALHOST Capability = "net-localhost"
+	CLOCK         Capability = "clock"
+)
+
+// Capabilities is what a host lets its scripts do. The zero value allows nothing at all, so running untrusted
+// scripts is safe by default and everything else has to be granted. Write, Exec, Env and NetLocalhost are reserved for
+// natives that don't exist yet: only Read (for imports) and Clock make a difference today.
+type Capabilities struct {
+	// Read and Write list the directories whose files (at any depth) scripts may read and write
+	Read  []string
+	Write []string
+
+	Exec         bool
+	Env          bool
+	NetLocalhost bool
+	Clock        bool
+}
+
+// AllCapabilities allows scripts to do anything, which is how the jota command runs them unless it's sandboxed.
+func AllCapabilities() Capabilities {
+	root := string(filepath.Separator)
+	return Capabilities{Read: []string{root}, Write: []string{root}, Exec: true, Env: true, NetLocalhost: true, Clock: true}
+}
+
+// Has reports whether a capability was granted at all. For files that means for at least one directory.
+func (c Capabilities) Has(capability Capability) bool {
+	switch capability {
+	case "":
+		return true
+	case FS_READ:
+		return len(c.Read) > 0
+	case FS_WRITE:
+		return len(c.Write) > 0
+	case EXEC:
+		return c.Exec
+	case ENV:
+		return c.Env
+	case NET_LOCALHOST:
+		return c.NetLocalhost
+	case CLOCK:
+		return c.Clock
+	}
+	return false
+}
+
+// CanRead reports whether scripts may read the file at path.
+func (c Capabilities) CanRead(path string) bool {
+	return within(path, c.Read)
+}
+
+// CanWrite reports whether scripts may write the file at path.
+func (c Capabilities) CanWrite(path string) bool {
+	return within(path, c.Write)
+}
+
+// Guard hands back a native that fails with a permission error when it's called, if it needs a capability that
+// wasn't granted. Natives that were allowed are returned as they are.
+func (c Capabilities) Guard(native Native) Native {
+	if c.Has(native.Needs) {
+		return native
+	}
+
+	message := native.Name + "() needs the '" + string(native.Needs) + "' capability, which this script wasn't given"
+	native.NativeLogic = func(engine Engine, arguments []any) any {
+		panic(Error{Code: errors.PERMISSION_DENIED, Message: message})
+	}
+	return native
+}
+
+// within is true if path is one of the directories or anywhere inside them. Both sides are made absolute, with
+// symlinks followed, so neither '..' nor a link can be used to climb out.
+func within(path string, directories []string) bool {
+	absolute, err := canonical(path)
+	if err != nil {
+		return false
+	}
+
+	for _, directory := range directories {
+		root, err := canonical(directory)
+		if err != nil {
+			continue
+		}
+		relative, err := filepath.Rel(root, absolute)
+		if err == nil && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// canonical makes path absolute and follows its symlinks. If it doesn't exist (yet), they're followed as far as it
+// does, so whether a file is there doesn't change which directory it's in.
+func canonical(path string) (string, error) {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	rest := ""
+	for current := absolute; ; {
+		if resolved, err := filepath.EvalSymlinks(current); err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return absolute, nil
+		}
+		rest = filepath.Join(filepath.Base(current), rest)
+		current = parent
+	}
+}
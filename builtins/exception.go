@@ -0,0 +1,65 @@
+package builtins
+
+import (
+	"jota/ast"
+	"jota/errors"
+)
+
+// Exception is the value a catch clause receives for errors raised by the runtime itself (a bad operand, an
+// undefined variable, a wrong number of arguments...). Scripts read its message, code, line and stack fields.
+type Exception struct {
+	Message string
+	Code    errors.Code
+	// Token is where the error was raised, so rethrowing it reports the same spot
+	Token  ast.Token
+	Frames []errors.StackFrame
+}
+
+func (e *Exception) String() string {
+	return "<error: " + e.Message + ">"
+}
+
+func (e *Exception) Field(name string) any {
+	switch name {
+	case "message":
+		return e.Message
+	case "code":
+		return string(e.Code)
+	case "line":
+		return float64(e.Token.Line)
+	case "stack":
+		stack := make([]any, len(e.Frames))
+		for i, frame := range e.Frames {
+			stack[i] = frame.String()
+		}
+		return NewList(stack)
+	}
+	panic(Error{Code: errors.UNDEFINED_PROPERTY, Message: "errors only have 'message', 'code', 'line' and 'stack' fields, not '" + name + "'"})
+}
+
+// Thrown wraps a value from a throw statement into the runtime error that carries it up to a catch clause.
+// Rethrowing a caught Exception keeps the code, position and stack it was first raised with.
+func Thrown(keyword ast.Token, value any) errors.RuntimeError {
+	if exception, ok := value.(*Exception); ok {
+		return errors.RuntimeError{
+			Token: exception.Token, Code: exception.Code, Message: exception.Message, Value: exception, Stack: exception.Frames,
+		}
+	}
+	if value == nil {
+		return errors.RuntimeError{Token: keyword, Code: errors.INVALID_VALUE, Message: "can't throw nil"}
+	}
+	return errors.RuntimeError{Token: keyword, Code: errors.UNCAUGHT_THROW, Message: Stringify(value), Value: value}
+}
+
+// Caught is the value a catch clause binds for an error: whatever was thrown, or an Exception describing an error
+// raised by the runtime.
+func Caught(err errors.RuntimeError) any {
+	if err.Value != nil {
+		return err.Value
+	}
+	code := err.Code
+	if code == "" {
+		code = errors.RUNTIME_ERROR
+	}
+	return &Exception{Message: err.Message, Code: code, Token: err.Token, Frames: err.Stack}
+}
@@ -0,0 +1,140 @@
+package builtins
+
+import (
+	"fmt"
+	"jota/errors"
+	"math"
+)
+
+// Iterator walks over an iterable value one element at a time, producing (key, value) pairs: (index, element) for
+// lists, (index, character) for strings, (key, value) for maps and (index, number) for ranges.
+type Iterator interface {
+	// Next returns the next pair, or ok == false once there's nothing left.
+	Next() (key, value any, ok bool)
+}
+
+// Iterate starts iterating over a value. With single set, the loop only binds one variable, which gets the value of
+// each pair - except for maps, where it gets the key.
+func Iterate(iterable any, single bool) Iterator {
+	switch iterable := iterable.(type) {
+	case *List:
+		return &listIterator{list: iterable}
+	case string:
+		return &stringIterator{runes: []rune(iterable)}
+	case *Map:
+		// Keys are snapshotted so that changing the map inside the loop can't skip or repeat entries
+		keys := make([]any, len(iterable.keys))
+		copy(keys, iterable.keys)
+		return &mapIterator{m: iterable, keys: keys, single: single}
+	case Range:
+		return &rangeIterator{r: iterable}
+	}
+
+	panic(Error{Code: errors.TYPE_ERROR, Message: "can only iterate over lists, maps, strings and ranges"})
+}
+
+type listIterator struct {
+	list  *List
+	index int
+}
+
+func (it *listIterator) Next() (any, any, bool) {
+	// The length is checked on every step, so elements pushed during the loop are visited too
+	if it.index >= len(it.list.Elements) {
+		return nil, nil, false
+	}
+	it.index++
+	return float64(it.index - 1), it.list.Elements[it.index-1], true
+}
+
+type stringIterator struct {
+	runes []rune
+	index int
+}
+
+func (it *stringIterator) Next() (any, any, bool) {
+	if it.index >= len(it.runes) {
+		return nil, nil, false
+	}
+	it.index++
+	return float64(it.index - 1), string(it.runes[it.index-1]), true
+}
+
+type mapIterator struct {
+	m      *Map
+	keys   []any
+	index  int
+	single bool
+}
+
+func (it *mapIterator) Next() (any, any, bool) {
+	for it.index < len(it.keys) {
+		key := it.keys[it.index]
+		it.index++
+
+		// Entries deleted since the loop started are skipped
+		value, ok := it.m.values[key]
+		if !ok {
+			continue
+		}
+		if it.single {
+			return nil, key, true
+		}
+		return key, value, true
+	}
+	return nil, nil, false
+}
+
+// Range is the lazy sequence made by range(). It never allocates its numbers up front, so huge ranges are cheap.
+type Range struct {
+	Start, Stop, Step float64
+}
+
+func (r Range) String() string {
+	return "range(" + Stringify(r.Start) + ", " + Stringify(r.Stop) + ", " + Stringify(r.Step) + ")"
+}
+
+type rangeIterator struct {
+	r     Range
+	index int
+}
+
+func (it *rangeIterator) Next() (any, any, bool) {
+	// Multiplying instead of repeatedly adding the step keeps floating point errors from piling up
+	value := it.r.Start + float64(it.index)*it.r.Step
+	if (it.r.Step > 0 && value >= it.r.Stop) || (it.r.Step < 0 && value <= it.r.Stop) {
+		return nil, nil, false
+	}
+	it.index++
+	return float64(it.index - 1), value, true
+}
+
+func makeRange(engine Engine, arguments []any) any {
+	if len(arguments) < 1 || len(arguments) > 3 {
+		panic(Error{Code: errors.WRONG_ARITY, Message: "range() expects between 1 and 3 arguments but got " + fmt.Sprint(len(arguments))})
+	}
+
+	numbers := make([]float64, len(arguments))
+	for i, argument := range arguments {
+		number, ok := argument.(float64)
+		if !ok || math.IsNaN(number) {
+			panic(Error{Code: errors.TYPE_ERROR, Message: "range() expects numbers"})
+		}
+		numbers[i] = number
+	}
+
+	r := Range{Start: 0, Step: 1}
+	switch len(numbers) {
+	case 1:
+		r.Stop = numbers[0]
+	case 2:
+		r.Start, r.Stop = numbers[0], numbers[1]
+	case 3:
+		r.Start, r.Stop, r.Step = numbers[0], numbers[1], numbers[2]
+	}
+
+	if r.Step == 0 {
+		panic(Error{Code: errors.INVALID_VALUE, Message: "range() step can't be zero"})
+	}
+	return r
+}
@@ -0,0 +1,130 @@
+package builtins
+
+import (
+	"context"
+	"jota/errors"
+	"strconv"
+	"time"
+)
+
+// Limits bound how much a single run may do, for scripts that can't be trusted to finish. The zero value means no
+// limits, apart from the default recursion depth.
+type Limits struct {
+	// MaxSteps is how many loop iterations and calls a run may make in total. Both engines count them the same way.
+	MaxSteps int
+	// Timeout is how long a run may take
+	Timeout time.Duration
+	// MaxMemory is roughly how many bytes of strings, lists, maps and instances a run may allocate in total. It counts
+	// everything allocated, not just what's still in use, so a script that keeps building and dropping big strings
+	// hits it too.
+	MaxMemory int
+	// MaxDepth is how many calls may be running at once, counting the script itself, or DEFAULT_MAX_DEPTH if it's 0.
+	// Going over it is an ordinary runtime error (which scripts can catch), since it's a bug in the script. It can't
+	// go above MAX_DEPTH.
+	MaxDepth int
+}
+
+// DEFAULT_MAX_DEPTH is deep enough for any sensible recursion, and shallow enough that the tree-walking interpreter
+// doesn't run out of Go stack first (which would crash the whole process).
+const DEFAULT_MAX_DEPTH = 10000
+
+// MAX_DEPTH is the deepest the tree-walking interpreter can safely recurse, leaving room for functions whose bodies
+// nest a lot of statements and expressions around their recursive call.
+const MAX_DEPTH = 25000
+
+// Depth is the recursion depth these limits allow.
+func (l Limits) Depth() int {
+	if l.MaxDepth > MAX_DEPTH {
+		return MAX_DEPTH
+	}
+	if l.MaxDepth > 0 {
+		return l.MaxDepth
+	}
+	return DEFAULT_MAX_DEPTH
+}
+
+// DepthError is the error for calls nesting deeper than depth allows.
+func DepthError(depth int) Error {
+	return Error{Code: errors.STACK_OVERFLOW, Message: "maximum recursion depth " + strconv.Itoa(depth) + " exceeded"}
+}
+
+// Checking the clock and the context on every step would slow loops down noticeably
+const CHECK_INTERVAL = 256
+
+// Rough sizes of values in bytes, for the memory limit. Strings cost their length in bytes on top.
+const (
+	// VALUE_SIZE is the cost of each list element, and of each key and value in a map
+	VALUE_SIZE = 16
+	// COLLECTION_SIZE is the cost of a list or map itself
+	COLLECTION_SIZE = 64
+)
+
+// Budget is what's left of the Limits for the run in progress.
+type Budget struct {
+	limits   Limits
+	ctx      context.Context
+	deadline time.Time
+	steps    int
+	memory   int
+	exceeded *errors.LimitError
+}
+
+// Start begins a run under these limits, which also stops once ctx is done.
+func (l Limits) Start(ctx context.Context) *Budget {
+	budget := &Budget{limits: l, ctx: ctx}
+	if l.Timeout > 0 {
+		budget.deadline = time.Now().Add(l.Timeout)
+	}
+	return budget
+}
+
+// Step counts a loop iteration or a call, and returns the error to stop the script with if that went over budget.
+// The error has no token yet; the engine knows where the script was. Once over budget, every later step fails too,
+// though the engines stop the script straight away, without running finally blocks or catching the error.
+func (b *Budget) Step() *errors.LimitError {
+	if b.exceeded != nil {
+		return b.exceeded
+	}
+
+	b.steps++
+	if b.limits.MaxSteps > 0 && b.steps > b.limits.MaxSteps {
+		return b.exceed(errors.STEP_LIMIT, "step limit of "+strconv.Itoa(b.limits.MaxSteps)+" exceeded")
+	}
+	if b.steps%CHECK_INTERVAL != 1 {
+		return nil
+	}
+
+	if !b.deadline.IsZero() && time.Now().After(b.deadline) {
+		return b.exceed(errors.TIME_LIMIT, "time limit of "+b.limits.Timeout.String()+" exceeded")
+	}
+	if err := b.ctx.Err(); err != nil {
+		canceled := CanceledError(err)
+		b.exceeded = &canceled
+		return b.exceeded
+	}
+	return nil
+}
+
+// CanceledError is the error for a run whose context is done, err being ctx.Err().
+func CanceledError(err error) errors.LimitError {
+	return errors.LimitError{RuntimeError: errors.RuntimeError{Code: errors.LIMIT_EXCEEDED, Message: "run canceled: " + err.Error()}, Limit: errors.CANCELED}
+}
+
+// Allocate counts bytes about to be allocated for a value, and returns the error to stop the script with if that goes
+// over budget. Like Step, it's up to the engine to say where the script was.
+func (b *Budget) Allocate(bytes int) *errors.LimitError {
+	if b.exceeded != nil {
+		return b.exceeded
+	}
+
+	b.memory += bytes
+	if b.limits.MaxMemory > 0 && b.memory > b.limits.MaxMemory {
+		return b.exceed(errors.MEMORY_LIMIT, "memory limit of "+strconv.Itoa(b.limits.MaxMemory)+" bytes exceeded")
+	}
+	return nil
+}
+
+func (b *Budget) exceed(limit errors.Limit, message string) *errors.LimitError {
+	b.exceeded = &errors.LimitError{RuntimeError: errors.RuntimeError{Code: errors.LIMIT_EXCEEDED, Message: message}, Limit: limit}
+	return b.exceeded
+}
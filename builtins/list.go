@@ -0,0 +1,269 @@
+package builtins
+
+import (
+	"fmt"
+	"jota/errors"
+	"math"
+	"sort"
+)
+
+// List is the runtime value behind list literals. It's always handled through a pointer, so every variable
+// holding the same list sees its changes.
+type List struct {
+	Elements []any
+}
+
+func NewList(elements []any) *List {
+	return &List{Elements: elements}
+}
+
+func (l *List) String() string {
+	return Stringify(l)
+}
+
+// Index reads list[index] or string[index]. Negative indices count from the end.
+func Index(object, index any) any {
+	switch object := object.(type) {
+	case *List:
+		return object.Elements[position(index, len(object.Elements), "list")]
+	case string:
+		runes := []rune(object)
+		return string(runes[position(index, len(runes), "string")])
+	case *Map:
+		value, ok := object.Get(index)
+		if !ok {
+			panic(Error{Code: errors.KEY_NOT_FOUND, Message: "key " + stringifyElement(index, make(map[any]bool)) + " not found in map"})
+		}
+		return value
+	}
+
+	panic(Error{Code: errors.TYPE_ERROR, Message: "only lists, strings and maps can be indexed"})
+}
+
+// SetIndex performs list[index] = value or map[key] = value.
+func SetIndex(engine Engine, object, index, value any) {
+	switch object := object.(type) {
+	case *List:
+		object.Elements[position(index, len(object.Elements), "list")] = value
+		return
+	case *Map:
+		if _, ok := object.Get(index); !ok {
+			engine.Allocate(2 * VALUE_SIZE)
+		}
+		object.Set(index, value)
+		return
+	}
+
+	panic(Error{Code: errors.TYPE_ERROR, Message: "only lists and maps support index assignment"})
+}
+
+// Slice returns the part of a list or string between start (inclusive) and end (exclusive). Either bound may be nil
+// to mean the beginning or the end, and out-of-range bounds are clamped instead of failing.
+func Slice(engine Engine, object, start, end any) any {
+	switch object := object.(type) {
+	case *List:
+		from, to := bounds(start, end, len(object.Elements))
+		engine.Allocate(COLLECTION_SIZE + (to-from)*VALUE_SIZE)
+		elements := make([]any, to-from)
+		copy(elements, object.Elements[from:to])
+		return NewList(elements)
+	case string:
+		runes := []rune(object)
+		from, to := bounds(start, end, len(runes))
+		slice := string(runes[from:to])
+		engine.Allocate(len(slice))
+		return slice
+	}
+
+	panic(Error{Code: errors.TYPE_ERROR, Message: "only lists and strings can be sliced"})
+}
+
+func length(engine Engine, arguments []any) any {
+	switch value := arguments[0].(type) {
+	case *List:
+		return float64(len(value.Elements))
+	case string:
+		return float64(len([]rune(value)))
+	case *Map:
+		return float64(value.Len())
+	}
+
+	panic(Error{Code: errors.TYPE_ERROR, Message: "len() expects a list, a string or a map"})
+}
+
+func push(engine Engine, arguments []any) any {
+	list := listArgument("push", arguments[0])
+	engine.Allocate(VALUE_SIZE)
+	list.Elements = append(list.Elements, arguments[1])
+	return nil
+}
+
+func pop(engine Engine, arguments []any) any {
+	list := listArgument("pop", arguments[0])
+	if len(list.Elements) == 0 {
+		panic(Error{Code: errors.INVALID_VALUE, Message: "can't pop from an empty list"})
+	}
+
+	last := list.Elements[len(list.Elements)-1]
+	list.Elements = list.Elements[:len(list.Elements)-1]
+	return last
+}
+
+func insert(engine Engine, arguments []any) any {
+	list := listArgument("insert", arguments[0])
+	// Inserting right after the last element is allowed, hence the + 1
+	index := position(arguments[1], len(list.Elements)+1, "list")
+	engine.Allocate(VALUE_SIZE)
+
+	list.Elements = append(list.Elements, nil)
+	copy(list.Elements[index+1:], list.Elements[index:])
+	list.Elements[index] = arguments[2]
+	return nil
+}
+
+func remove(engine Engine, arguments []any) any {
+	list := listArgument("remove", arguments[0])
+	index := position(arguments[1], len(list.Elements), "list")
+
+	removed := list.Elements[index]
+	list.Elements = append(list.Elements[:index], list.Elements[index+1:]...)
+	return removed
+}
+
+func mapList(engine Engine, arguments []any) any {
+	elements := iterableArgument(engine, "map", arguments[0])
+	for i, element := range elements {
+		elements[i] = engine.Call(arguments[1], []any{element})
+	}
+	return NewList(elements)
+}
+
+func filter(engine Engine, arguments []any) any {
+	elements := []any{}
+	for _, element := range iterableArgument(engine, "filter", arguments[0]) {
+		if IsTruthy(engine.Call(arguments[1], []any{element})) {
+			elements = append(elements, element)
+		}
+	}
+	return NewList(elements)
+}
+
+// sortList returns a sorted list of the elements. Numbers and strings sort on their own; anything else needs a
+// function that takes two elements and returns true when the first one should come first.
+func sortList(engine Engine, arguments []any) any {
+	if len(arguments) < 1 || len(arguments) > 2 {
+		panic(Error{Code: errors.WRONG_ARITY, Message: "sort() expects 1 or 2 arguments but got " + fmt.Sprint(len(arguments))})
+	}
+
+	elements := iterableArgument(engine, "sort", arguments[0])
+
+	less := naturalLess
+	if len(arguments) == 2 {
+		less = func(a, b any) bool {
+			return IsTruthy(engine.Call(arguments[1], []any{a, b}))
+		}
+	}
+
+	sort.SliceStable(elements, func(i, j int) bool {
+		return less(elements[i], elements[j])
+	})
+	return NewList(elements)
+}
+
+func naturalLess(a, b any) bool {
+	switch a := a.(type) {
+	case float64:
+		if b, ok := b.(float64); ok {
+			return a < b
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return a < b
+		}
+	}
+	panic(Error{Code: errors.TYPE_ERROR, Message: "sort() can only compare numbers with numbers and strings with strings (pass a comparison function for anything else)"})
+}
+
+// iterableArgument collects the elements of anything a one-variable for-in loop could walk over into a new slice.
+func iterableArgument(engine Engine, name string, value any) []any {
+	engine.Allocate(COLLECTION_SIZE)
+	if list, ok := value.(*List); ok {
+		engine.Allocate(len(list.Elements) * VALUE_SIZE)
+		elements := make([]any, len(list.Elements))
+		copy(elements, list.Elements)
+		return elements
+	}
+
+	switch value.(type) {
+	case string, *Map, Range:
+	default:
+		panic(Error{Code: errors.TYPE_ERROR, Message: name + "() expects a list, map, string or range as its first argument"})
+	}
+
+	elements := []any{}
+	iterator := Iterate(value, true)
+	for {
+		_, element, ok := iterator.Next()
+		if !ok {
+			return elements
+		}
+		// Counted as it goes, since a range can be far too long to collect
+		engine.Allocate(VALUE_SIZE)
+		elements = append(elements, element)
+	}
+}
+
+func listArgument(name string, value any) *List {
+	list, ok := value.(*List)
+	if !ok {
+		panic(Error{Code: errors.TYPE_ERROR, Message: name + "() expects a list as its first argument"})
+	}
+	return list
+}
+
+// position validates an index against a length, resolving negative indices from the end.
+func position(index any, length int, kind string) int {
+	i := wholeNumber(index, kind+" index")
+	if i < 0 {
+		i += length
+	}
+	if i < 0 || i >= length {
+		panic(Error{Code: errors.INDEX_OUT_OF_RANGE, Message: kind + " index out of range"})
+	}
+	return i
+}
+
+func bounds(start, end any, length int) (int, int) {
+	from, to := 0, length
+	if start != nil {
+		from = clamp(wholeNumber(start, "slice bound"), length)
+	}
+	if end != nil {
+		to = clamp(wholeNumber(end, "slice bound"), length)
+	}
+	if from > to {
+		from = to
+	}
+	return from, to
+}
+
+func clamp(i, length int) int {
+	if i < 0 {
+		i += length
+	}
+	if i < 0 {
+		return 0
+	}
+	if i > length {
+		return length
+	}
+	return i
+}
+
+func wholeNumber(value any, what string) int {
+	number, ok := value.(float64)
+	if !ok || number != math.Trunc(number) || math.IsInf(number, 0) {
+		panic(Error{Code: errors.INVALID_VALUE, Message: what + " must be a whole number"})
+	}
+	return int(number)
+}
@@ -0,0 +1,108 @@
+package builtins
+
+import "jota/errors"
+
+// Map is the runtime value behind map literals. It remembers the order keys were first added in, so iterating over
+// it (or printing it) gives the same result every run.
+type Map struct {
+	keys   []any
+	values map[any]any
+}
+
+func NewMap() *Map {
+	return &Map{values: make(map[any]any)}
+}
+
+func (m *Map) Get(key any) (any, bool) {
+	checkKey(key)
+	value, ok := m.values[key]
+	return value, ok
+}
+
+func (m *Map) Set(key, value any) {
+	checkKey(key)
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+func (m *Map) Delete(key any) {
+	checkKey(key)
+	if _, ok := m.values[key]; !ok {
+		return
+	}
+
+	delete(m.values, key)
+	for i, k := range m.keys {
+		if k == key {
+			m.keys = append(m.keys[:i], m.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// Keys returns the keys in insertion order. The slice must not be modified.
+func (m *Map) Keys() []any {
+	return m.keys
+}
+
+func (m *Map) Len() int {
+	return len(m.keys)
+}
+
+func (m *Map) String() string {
+	return Stringify(m)
+}
+
+// Only immutable values can be keys, otherwise changing a list after using it as a key would lose the entry. Looking
+// a key up is checked the same way, as some values (like functions) can't be used as Go map keys at all.
+func checkKey(key any) {
+	switch key := key.(type) {
+	case float64:
+		// NaN isn't equal to anything, itself included, so an entry under it could never be found again
+		if key != key {
+			panic(Error{Code: errors.TYPE_ERROR, Message: "map keys can't be NaN"})
+		}
+		return
+	case nil, bool, string:
+		return
+	}
+	panic(Error{Code: errors.TYPE_ERROR, Message: "map keys must be numbers, strings, booleans or nil"})
+}
+
+func keys(engine Engine, arguments []any) any {
+	m := mapArgument("keys", arguments[0])
+	engine.Allocate(COLLECTION_SIZE + len(m.keys)*VALUE_SIZE)
+	elements := make([]any, len(m.keys))
+	copy(elements, m.keys)
+	return NewList(elements)
+}
+
+func values(engine Engine, arguments []any) any {
+	m := mapArgument("values", arguments[0])
+	engine.Allocate(COLLECTION_SIZE + len(m.keys)*VALUE_SIZE)
+	elements := make([]any, len(m.keys))
+	for i, key := range m.keys {
+		elements[i] = m.values[key]
+	}
+	return NewList(elements)
+}
+
+func has(engine Engine, arguments []any) any {
+	_, ok := mapArgument("has", arguments[0]).Get(arguments[1])
+	return ok
+}
+
+func deleteKey(engine Engine, arguments []any) any {
+	mapArgument("delete", arguments[0]).Delete(arguments[1])
+	return nil
+}
+
+func mapArgument(name string, value any) *Map {
+	m, ok := value.(*Map)
+	if !ok {
+		panic(Error{Code: errors.TYPE_ERROR, Message: name + "() expects a map as its first argument"})
+	}
+	return m
+}
@@ -0,0 +1,23 @@
+package builtins
+
+import "jota/errors"
+
+// Module is what 'import "path" as name;' binds: the globals an imported file defined, read with name.export.
+type Module struct {
+	Name    string
+	Path    string
+	Exports map[string]any
+}
+
+func (m *Module) String() string {
+	return "<module " + m.Name + ">"
+}
+
+// Export returns the value the module defined under name.
+func (m *Module) Export(name string) any {
+	value, ok := m.Exports[name]
+	if !ok {
+		panic(Error{Code: errors.UNDEFINED_PROPERTY, Message: "module '" + m.Name + "' has no export named '" + name + "'"})
+	}
+	return value
+}
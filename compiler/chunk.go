@@ -0,0 +1,151 @@
+package compiler
+
+import (
+	"jota/ast"
+	"sort"
+)
+
+type OpCode byte
+
+const (
+	OP_CONSTANT OpCode = iota
+	OP_NIL
+	OP_TRUE
+	OP_FALSE
+	OP_POP
+
+	// Variables
+	OP_GET_LOCAL
+	OP_SET_LOCAL
+	OP_GET_GLOBAL
+	OP_DEFINE_GLOBAL
+	OP_SET_GLOBAL
+	OP_GET_UPVALUE
+	OP_SET_UPVALUE
+	OP_GET_PROPERTY
+	OP_SET_PROPERTY
+	OP_GET_SUPER
+
+	// Operators
+	OP_EQUAL
+	OP_NOT_EQUAL
+	OP_GREATER
+	OP_GREATER_EQUAL
+	OP_LESS
+	OP_LESS_EQUAL
+	OP_ADD
+	OP_SUBTRACT
+	OP_MULTIPLY
+	OP_DIVIDE
+	OP_MODULO
+	OP_POWER
+	OP_NOT
+	OP_NEGATE
+	OP_INCREMENT
+	OP_DECREMENT
+
+	// Statements & control flow
+	OP_PRINT
+	OP_JUMP
+	OP_JUMP_IF_FALSE
+	OP_LOOP
+	OP_CALL
+	OP_CLOSURE
+	OP_CLOSE_UPVALUE
+	OP_RETURN
+
+	// Classes
+	OP_CLASS
+	OP_INHERIT
+	OP_METHOD
+
+	// Strings
+	OP_INTERPOLATE
+
+	// Lists
+	OP_BUILD_LIST
+	OP_GET_INDEX
+	OP_SET_INDEX
+	OP_SLICE
+
+	// Maps
+	OP_BUILD_MAP
+
+	// For-in loops
+	OP_ITERATE
+	OP_FOR_ITER
+
+	// Modules
+	OP_IMPORT
+	OP_IMPORT_NAME
+
+	// Exceptions
+	OP_TRY
+	OP_END_TRY
+	OP_CATCH
+	OP_THROW
+	OP_RETHROW
+)
+
+// Chunk is a compiled sequence of instructions. Runtime errors point back at the source through Token.
+type Chunk struct {
+	Code      []byte
+	Constants []any
+	// The bytes compiled from each token come one after the other (an instruction and its operands at least), so a
+	// token is only kept once for all of them: tokens[i] is where the bytes from starts[i] up to starts[i+1] came from.
+	tokens []ast.Token
+	starts []int
+	// Where each constant is in Constants, so adding one that's already there reuses it
+	constants map[any]int
+}
+
+func (c *Chunk) Write(b byte, token ast.Token) {
+	if last := len(c.tokens) - 1; last < 0 || !sameToken(c.tokens[last], token) {
+		c.tokens = append(c.tokens, token)
+		c.starts = append(c.starts, len(c.Code))
+	}
+	c.Code = append(c.Code, b)
+}
+
+// Token is the source token of the byte at offset in Code, or of the instruction it's an operand of.
+func (c *Chunk) Token(offset int) ast.Token {
+	// The last run of bytes starting at or before offset
+	run := sort.Search(len(c.starts), func(i int) bool { return c.starts[i] > offset }) - 1
+	return c.tokens[run]
+}
+
+func sameToken(a, b ast.Token) bool {
+	return a.Source == b.Source && a.Offset == b.Offset && a.Line == b.Line && a.Column == b.Column && a.Type == b.Type && a.Lexeme == b.Lexeme
+}
+
+// AddConstant returns the index of value in Constants, adding it if it isn't there yet. Constants are only ever nil,
+// booleans, numbers, strings and functions, which can all be map keys.
+func (c *Chunk) AddConstant(value any) int {
+	if index, ok := c.constants[value]; ok {
+		return index
+	}
+	if c.constants == nil {
+		c.constants = make(map[any]int)
+	}
+
+	c.Constants = append(c.Constants, value)
+	c.constants[value] = len(c.Constants) - 1
+	return len(c.Constants) - 1
+}
+
+// Function is the compiled form of a function body (or of the whole script, in which case Name is empty). File is
+// the source file it came from, for stack traces.
+type Function struct {
+	Name         string
+	File         string
+	Arity        int
+	UpvalueCount int
+	Chunk        Chunk
+}
+
+func (f *Function) String() string {
+	if f.Name == "" {
+		return "<script>"
+	}
+	return "<fn " + f.Name + ">"
+}
@@ -0,0 +1,984 @@
+package compiler
+
+import (
+	"jota/ast"
+	"jota/errors"
+	"math"
+)
+
+type FunctionKind int
+
+const (
+	SCRIPT FunctionKind = iota
+	FUNCTION
+	METHOD
+	INITIALIZER
+)
+
+const maxLocals = math.MaxUint8 + 1
+
+type local struct {
+	name       string
+	depth      int
+	isCaptured bool
+}
+
+type upvalue struct {
+	index   byte
+	isLocal bool
+}
+
+// loop tracks the jumps a break or continue inside a loop needs. scopeDepth is the depth right outside the
+// loop's own variables, so jumping out pops everything declared deeper than it. tries is how many try blocks were
+// already open when the loop started; the ones opened inside it have to be left on the way out.
+type loop struct {
+	label      string
+	scopeDepth int
+	tries      int
+	start      int
+	// keyword is what the jump back to the start points at, so going over a step limit is reported at the loop
+	keyword       ast.Token
+	continueJumps []int
+	breakJumps    []int
+}
+
+// tryBlock is a try (or catch) block being compiled. locals is how many locals existed right before it, which is
+// all its finally block can see.
+type tryBlock struct {
+	finally *ast.BlockStatement
+	locals  int
+}
+
+type classCompiler struct {
+	enclosing     *classCompiler
+	hasSuperclass bool
+}
+
+// Compiler lowers the AST into bytecode for the vm package. Every function body gets its own Compiler, linked to
+// the one it's nested in so that variables from enclosing functions can be captured as upvalues.
+type Compiler struct {
+	Errors errors.Sink
+
+	enclosing  *Compiler
+	function   *Function
+	kind       FunctionKind
+	locals     []local
+	upvalues   []upvalue
+	scopeDepth int
+	class      *classCompiler
+	loops      []*loop
+	tries      []*tryBlock
+	// token is the one instructions are currently emitted for, so runtime errors can point back at it
+	token    ast.Token
+	hadError bool
+}
+
+func newCompiler(enclosing *Compiler, kind FunctionKind, name string, sink errors.Sink) *Compiler {
+	c := &Compiler{
+		Errors:    sink,
+		enclosing: enclosing,
+		function:  &Function{Name: name},
+		kind:      kind,
+	}
+	if enclosing != nil {
+		c.class = enclosing.class
+		c.token = enclosing.token
+		c.function.File = enclosing.function.File
+	}
+
+	// Slot zero holds the function being called, or the instance for methods
+	slotName := ""
+	if kind == METHOD || kind == INITIALIZER {
+		slotName = "this"
+	}
+	c.locals = append(c.locals, local{name: slotName, depth: 0})
+	return c
+}
+
+// Compile turns a parsed program from the given file ("" for the REPL) into the top-level script function. It
+// returns nil if any compile error was reported.
+func Compile(statements []ast.Statement, file string, sink errors.Sink) *Function {
+	c := newCompiler(nil, SCRIPT, "", sink)
+	c.function.File = file
+	for _, statement := range statements {
+		c.compileStatement(statement)
+	}
+	c.emitReturn()
+
+	if c.hadError {
+		return nil
+	}
+	return c.function
+}
+
+func (c *Compiler) VisitExpressionStatement(statement *ast.ExpressionStatement) any {
+	c.compileExpression(statement.Expression)
+	c.emitOp(OP_POP)
+	return nil
+}
+
+func (c *Compiler) VisitPrintStatement(statement *ast.PrintStatement) any {
+	c.compileExpression(statement.Expression)
+	c.emitOp(OP_PRINT)
+	return nil
+}
+
+func (c *Compiler) VisitVariableStatement(statement *ast.VariableStatement) any {
+	c.token = statement.Name
+	c.declareVariable(statement.Name)
+
+	if statement.Initializer != nil {
+		c.compileExpression(statement.Initializer)
+	} else {
+		c.emitOp(OP_NIL)
+	}
+
+	c.token = statement.Name
+	c.defineVariable(statement.Name)
+	return nil
+}
+
+// Imports only happen at the top level (the resolver makes sure of it), so their names are always globals
+func (c *Compiler) VisitImportStatement(statement *ast.ImportStatement) any {
+	c.token = statement.Path
+	c.emitOpWithShort(OP_IMPORT, c.makeConstant(statement.Path.Literal.(string)))
+
+	if statement.Alias != nil {
+		c.defineVariable(*statement.Alias)
+		return nil
+	}
+
+	for _, name := range statement.Names {
+		c.token = name
+		c.emitOpWithShort(OP_IMPORT_NAME, c.identifierConstant(name))
+		c.defineVariable(name)
+	}
+	c.emitOp(OP_POP)
+	return nil
+}
+
+func (c *Compiler) VisitBlockStatement(statement *ast.BlockStatement) any {
+	c.beginScope()
+	for _, statement := range statement.Statements {
+		c.compileStatement(statement)
+	}
+	c.endScope()
+	return nil
+}
+
+func (c *Compiler) VisitIfStatement(statement *ast.IfStatement) any {
+	c.compileExpression(statement.Condition)
+
+	thenJump := c.emitJump(OP_JUMP_IF_FALSE)
+	c.emitOp(OP_POP)
+	c.compileStatement(statement.ThenBranch)
+
+	elseJump := c.emitJump(OP_JUMP)
+	c.patchJump(thenJump)
+	c.emitOp(OP_POP)
+
+	if statement.ElseBranch != nil {
+		c.compileStatement(statement.ElseBranch)
+	}
+	c.patchJump(elseJump)
+	return nil
+}
+
+func (c *Compiler) VisitWhileStatement(statement *ast.WhileStatement) any {
+	loopStart := len(c.currentChunk().Code)
+	c.compileExpression(statement.Condition)
+
+	exitJump := c.emitJump(OP_JUMP_IF_FALSE)
+	c.emitOp(OP_POP)
+
+	// Without an increment, continue can jump straight back to the condition
+	l := &loop{label: labelName(statement.Label), scopeDepth: c.scopeDepth, tries: len(c.tries), start: loopStart, keyword: statement.Keyword}
+	if statement.Increment != nil {
+		l.start = -1
+	}
+	c.loops = append(c.loops, l)
+	c.compileStatement(statement.Body)
+	c.loops = c.loops[:len(c.loops)-1]
+
+	for _, jump := range l.continueJumps {
+		c.patchJump(jump)
+	}
+	if statement.Increment != nil {
+		c.compileExpression(statement.Increment)
+		c.emitOp(OP_POP)
+	}
+	c.token = statement.Keyword
+	c.emitLoop(loopStart)
+
+	c.patchJump(exitJump)
+	c.emitOp(OP_POP)
+
+	for _, jump := range l.breakJumps {
+		c.patchJump(jump)
+	}
+	return nil
+}
+
+func (c *Compiler) VisitBreakStatement(statement *ast.BreakStatement) any {
+	c.token = statement.Keyword
+	l := c.targetLoop(statement.Label)
+	c.exitTries(l.tries)
+	c.discardLocals(l.scopeDepth)
+	l.breakJumps = append(l.breakJumps, c.emitJump(OP_JUMP))
+	return nil
+}
+
+func (c *Compiler) VisitContinueStatement(statement *ast.ContinueStatement) any {
+	c.token = statement.Keyword
+	l := c.targetLoop(statement.Label)
+	c.exitTries(l.tries)
+	c.discardLocals(l.scopeDepth)
+	if l.start == -1 {
+		l.continueJumps = append(l.continueJumps, c.emitJump(OP_JUMP))
+	} else {
+		c.token = l.keyword
+		c.emitLoop(l.start)
+	}
+	return nil
+}
+
+func (c *Compiler) VisitForInStatement(statement *ast.ForInStatement) any {
+	count := byte(len(statement.Variables))
+	c.compileExpression(statement.Iterable)
+
+	// The iterator lives in a hidden local for the whole loop. Its name can't clash with a real variable.
+	c.token = statement.In
+	c.emitOpWithByte(OP_ITERATE, count)
+	c.beginScope()
+	c.addLocal("for iterator")
+	c.markInitialized()
+
+	loopStart := len(c.currentChunk().Code)
+	c.emitOpWithByte(OP_FOR_ITER, count)
+	c.emitByte(0xff)
+	c.emitByte(0xff)
+	exitJump := len(c.currentChunk().Code) - 2
+
+	l := &loop{label: labelName(statement.Label), scopeDepth: c.scopeDepth, tries: len(c.tries), start: loopStart, keyword: statement.In}
+	c.loops = append(c.loops, l)
+
+	// OP_FOR_ITER pushes the loop variables, and they're popped (or closed over) at the end of every iteration
+	c.beginScope()
+	for _, variable := range statement.Variables {
+		c.declareVariable(variable)
+		c.markInitialized()
+	}
+	c.compileStatement(statement.Body)
+	c.endScope()
+	c.token = statement.In
+	c.emitLoop(loopStart)
+
+	c.loops = c.loops[:len(c.loops)-1]
+	c.patchJump(exitJump)
+	for _, jump := range l.breakJumps {
+		c.patchJump(jump)
+	}
+	c.endScope()
+	return nil
+}
+
+func (c *Compiler) VisitFunctionStatement(statement *ast.FunctionStatement) any {
+	c.token = statement.Name
+	c.declareVariable(statement.Name)
+	// Functions may refer to themselves, so the name is usable before the body is compiled
+	c.markInitialized()
+
+	c.compileFunction(statement, FUNCTION)
+	c.defineVariable(statement.Name)
+	return nil
+}
+
+func (c *Compiler) VisitReturnStatement(statement *ast.ReturnStatement) any {
+	c.token = statement.Keyword
+	if statement.Value == nil {
+		c.exitTries(0)
+		c.emitReturn()
+		return nil
+	}
+
+	c.compileExpression(statement.Value)
+	if len(c.tries) == 0 {
+		c.emitOp(OP_RETURN)
+		return nil
+	}
+
+	// The value waits in a hidden local while the finally blocks run, so that their own locals get the right slots
+	c.beginScope()
+	c.addLocal("return value")
+	c.markInitialized()
+	c.exitTries(0)
+	c.token = statement.Keyword
+	c.emitOp(OP_RETURN)
+	c.locals = c.locals[:len(c.locals)-1]
+	c.scopeDepth--
+	return nil
+}
+
+func (c *Compiler) VisitThrowStatement(statement *ast.ThrowStatement) any {
+	c.compileExpression(statement.Value)
+	c.token = statement.Keyword
+	c.emitOp(OP_THROW)
+	return nil
+}
+
+// VisitTryStatement guards the try block with a handler, which the VM jumps to with the error on the stack. A finally
+// block is copied onto every way out: the end of the try and catch blocks, any return, break or continue leaving them
+// (see exitTries), and the handlers that run it before passing on an error nothing caught.
+func (c *Compiler) VisitTryStatement(statement *ast.TryStatement) any {
+	c.token = statement.Keyword
+	handler := c.emitJump(OP_TRY)
+	c.tries = append(c.tries, &tryBlock{finally: statement.Finally, locals: len(c.locals)})
+	c.compileStatement(statement.Body)
+	c.tries = c.tries[:len(c.tries)-1]
+	c.emitOp(OP_END_TRY)
+	if statement.Finally != nil {
+		c.compileStatement(statement.Finally)
+	}
+	exit := c.emitJump(OP_JUMP)
+
+	c.patchJump(handler)
+	if statement.Catch != nil {
+		c.compileCatch(statement)
+	} else {
+		c.rethrowAfter(statement.Finally)
+	}
+	c.patchJump(exit)
+	return nil
+}
+
+func (c *Compiler) compileCatch(statement *ast.TryStatement) {
+	c.token = statement.Keyword
+	c.beginScope()
+	c.emitOp(OP_CATCH)
+	name := "caught error"
+	if statement.CatchName != nil {
+		name = statement.CatchName.Lexeme
+	}
+	c.addLocal(name)
+	c.markInitialized()
+
+	if statement.Finally == nil {
+		for _, statement := range statement.Catch.Statements {
+			c.compileStatement(statement)
+		}
+		c.endScope()
+		return
+	}
+
+	// Errors raised inside the catch block still have to run the finally block
+	handler := c.emitJump(OP_TRY)
+	c.tries = append(c.tries, &tryBlock{finally: statement.Finally, locals: len(c.locals) - 1})
+	for _, statement := range statement.Catch.Statements {
+		c.compileStatement(statement)
+	}
+	c.tries = c.tries[:len(c.tries)-1]
+	c.emitOp(OP_END_TRY)
+	c.endScope()
+	c.compileStatement(statement.Finally)
+	exit := c.emitJump(OP_JUMP)
+
+	// That handler starts with the caught error's variable still on the stack, under the new error
+	c.patchJump(handler)
+	c.beginScope()
+	c.addLocal("")
+	c.markInitialized()
+	c.rethrowAfter(statement.Finally)
+	c.endScope()
+	c.patchJump(exit)
+}
+
+// rethrowAfter compiles a handler that only runs the finally block, and then sends the error on its way.
+func (c *Compiler) rethrowAfter(finally *ast.BlockStatement) {
+	c.beginScope()
+	c.addLocal("caught error")
+	c.markInitialized()
+	c.compileStatement(finally)
+	c.emitOp(OP_RETHROW)
+	c.endScope()
+}
+
+// exitTries leaves the try blocks opened since there were depth of them, the way a return, break or continue does:
+// innermost first, each one's handler is dropped and its finally block runs.
+func (c *Compiler) exitTries(depth int) {
+	tries := c.tries
+	for i := len(tries) - 1; i >= depth; i-- {
+		// A return or jump inside the finally block itself must only leave the tries around this one
+		c.tries = tries[:i]
+		c.emitOp(OP_END_TRY)
+		if tries[i].finally != nil {
+			c.compileFinally(tries[i])
+		}
+	}
+	c.tries = tries
+}
+
+// compileFinally compiles a copy of a finally block while the variables of the block it guards are still on the
+// stack. They're hidden for the duration, so names resolve just like they would where the finally block is written.
+func (c *Compiler) compileFinally(try *tryBlock) {
+	hidden := make([]string, len(c.locals)-try.locals)
+	for i := range hidden {
+		hidden[i] = c.locals[try.locals+i].name
+		c.locals[try.locals+i].name = ""
+	}
+
+	c.compileStatement(try.finally)
+
+	for i, name := range hidden {
+		c.locals[try.locals+i].name = name
+	}
+}
+
+func (c *Compiler) VisitClassStatement(statement *ast.ClassStatement) any {
+	c.token = statement.Name
+	name := c.identifierConstant(statement.Name)
+	c.declareVariable(statement.Name)
+
+	c.emitOpWithShort(OP_CLASS, name)
+	c.defineVariable(statement.Name)
+
+	class := &classCompiler{enclosing: c.class}
+	c.class = class
+
+	if statement.Superclass != nil {
+		c.compileExpression(statement.Superclass)
+
+		c.beginScope()
+		c.addLocal("super")
+		c.markInitialized()
+
+		c.namedVariable(statement.Name)
+		c.token = statement.Superclass.Name
+		c.emitOp(OP_INHERIT)
+		class.hasSuperclass = true
+	}
+
+	c.namedVariable(statement.Name)
+	for _, method := range statement.Methods {
+		kind := METHOD
+		if method.Name.Lexeme == "init" {
+			kind = INITIALIZER
+		}
+		c.compileFunction(method, kind)
+		c.emitOpWithShort(OP_METHOD, c.identifierConstant(method.Name))
+	}
+	c.emitOp(OP_POP)
+
+	if class.hasSuperclass {
+		c.endScope()
+	}
+
+	c.class = class.enclosing
+	return nil
+}
+
+func (c *Compiler) VisitLiteralExpression(expression *ast.Literal) any {
+	switch value := expression.Value.(type) {
+	case nil:
+		c.emitOp(OP_NIL)
+	case bool:
+		if value {
+			c.emitOp(OP_TRUE)
+		} else {
+			c.emitOp(OP_FALSE)
+		}
+	default:
+		c.emitConstant(value)
+	}
+	return nil
+}
+
+func (c *Compiler) VisitGroupingExpression(expression *ast.Grouping) any {
+	c.compileExpression(expression.Expression)
+	return nil
+}
+
+func (c *Compiler) VisitUnaryExpression(expression *ast.Unary) any {
+	c.compileExpression(expression.Right)
+
+	c.token = expression.Operator
+	switch expression.Operator.Type {
+	case ast.MINUS:
+		c.emitOp(OP_NEGATE)
+	case ast.BANG:
+		c.emitOp(OP_NOT)
+	case ast.INCREMENT:
+		c.emitOp(OP_INCREMENT)
+	case ast.DECREMENT:
+		c.emitOp(OP_DECREMENT)
+	}
+	return nil
+}
+
+func (c *Compiler) VisitBinaryExpression(expression *ast.Binary) any {
+	c.compileExpression(expression.Left)
+	c.compileExpression(expression.Right)
+
+	c.token = expression.Operator
+	switch expression.Operator.Type {
+	case ast.MINUS:
+		c.emitOp(OP_SUBTRACT)
+	case ast.SLASH:
+		c.emitOp(OP_DIVIDE)
+	case ast.PERCENT:
+		c.emitOp(OP_MODULO)
+	case ast.ASTERISK:
+		c.emitOp(OP_MULTIPLY)
+	case ast.CARET:
+		c.emitOp(OP_POWER)
+	case ast.PLUS:
+		c.emitOp(OP_ADD)
+	case ast.GREATER:
+		c.emitOp(OP_GREATER)
+	case ast.GREATER_EQUAL:
+		c.emitOp(OP_GREATER_EQUAL)
+	case ast.LESS:
+		c.emitOp(OP_LESS)
+	case ast.LESS_EQUAL:
+		c.emitOp(OP_LESS_EQUAL)
+	case ast.EQUAL_EQUAL:
+		c.emitOp(OP_EQUAL)
+	case ast.BANG_EQUAL:
+		c.emitOp(OP_NOT_EQUAL)
+	}
+	return nil
+}
+
+func (c *Compiler) VisitLogicalExpression(expression *ast.Logical) any {
+	c.compileExpression(expression.Left)
+
+	if expression.Operator.Type == ast.OR {
+		elseJump := c.emitJump(OP_JUMP_IF_FALSE)
+		endJump := c.emitJump(OP_JUMP)
+
+		c.patchJump(elseJump)
+		c.emitOp(OP_POP)
+		c.compileExpression(expression.Right)
+		c.patchJump(endJump)
+		return nil
+	}
+
+	endJump := c.emitJump(OP_JUMP_IF_FALSE)
+	c.emitOp(OP_POP)
+	c.compileExpression(expression.Right)
+	c.patchJump(endJump)
+	return nil
+}
+
+func (c *Compiler) VisitVariableExpression(expression *ast.Variable) any {
+	c.namedVariable(expression.Name)
+	return nil
+}
+
+func (c *Compiler) VisitAssignExpression(expression *ast.Assign) any {
+	c.compileExpression(expression.Value)
+
+	c.token = expression.Name
+	_, setOp, arg := c.resolveVariable(expression.Name)
+	c.emitVariableOp(setOp, arg)
+	return nil
+}
+
+func (c *Compiler) VisitCallExpression(expression *ast.Call) any {
+	c.compileExpression(expression.Callee)
+	for _, argument := range expression.Arguments {
+		c.compileExpression(argument)
+	}
+
+	c.token = expression.Paren
+	c.emitOpWithByte(OP_CALL, byte(len(expression.Arguments)))
+	return nil
+}
+
+func (c *Compiler) VisitGetExpression(expression *ast.Get) any {
+	c.compileExpression(expression.Object)
+
+	c.token = expression.Name
+	c.emitOpWithShort(OP_GET_PROPERTY, c.identifierConstant(expression.Name))
+	return nil
+}
+
+func (c *Compiler) VisitSetExpression(expression *ast.Set) any {
+	c.compileExpression(expression.Object)
+	c.compileExpression(expression.Value)
+
+	c.token = expression.Name
+	c.emitOpWithShort(OP_SET_PROPERTY, c.identifierConstant(expression.Name))
+	return nil
+}
+
+func (c *Compiler) VisitThisExpression(expression *ast.This) any {
+	c.namedVariable(expression.Keyword)
+	return nil
+}
+
+func (c *Compiler) VisitSuperExpression(expression *ast.Super) any {
+	this := expression.Keyword
+	this.Type, this.Lexeme = ast.THIS, "this"
+	c.namedVariable(this)
+	c.namedVariable(expression.Keyword)
+
+	c.token = expression.Method
+	c.emitOpWithShort(OP_GET_SUPER, c.identifierConstant(expression.Method))
+	return nil
+}
+
+func (c *Compiler) VisitLambdaExpression(expression *ast.Lambda) any {
+	c.compileFunction(expression.Declaration, FUNCTION)
+	return nil
+}
+
+func (c *Compiler) VisitInterpolationExpression(expression *ast.Interpolation) any {
+	for _, part := range expression.Parts {
+		c.compileExpression(part)
+	}
+
+	c.token = expression.Quote
+	if len(expression.Parts) > math.MaxUint16 {
+		c.error(expression.Quote, "too many parts in an interpolated string")
+	}
+	c.emitOpWithShort(OP_INTERPOLATE, len(expression.Parts))
+	return nil
+}
+
+func (c *Compiler) VisitListLiteralExpression(expression *ast.ListLiteral) any {
+	for _, element := range expression.Elements {
+		c.compileExpression(element)
+	}
+
+	c.token = expression.Bracket
+	if len(expression.Elements) > math.MaxUint16 {
+		c.error(expression.Bracket, "too many elements in a list literal")
+	}
+	c.emitOpWithShort(OP_BUILD_LIST, len(expression.Elements))
+	return nil
+}
+
+func (c *Compiler) VisitMapLiteralExpression(expression *ast.MapLiteral) any {
+	for index := range expression.Keys {
+		c.compileExpression(expression.Keys[index])
+		c.compileExpression(expression.Values[index])
+	}
+
+	c.token = expression.Brace
+	if len(expression.Keys) > math.MaxUint16 {
+		c.error(expression.Brace, "too many entries in a map literal")
+	}
+	c.emitOpWithShort(OP_BUILD_MAP, len(expression.Keys))
+	return nil
+}
+
+func (c *Compiler) VisitIndexExpression(expression *ast.Index) any {
+	c.compileExpression(expression.Object)
+	c.compileExpression(expression.Index)
+
+	c.token = expression.Bracket
+	c.emitOp(OP_GET_INDEX)
+	return nil
+}
+
+func (c *Compiler) VisitIndexSetExpression(expression *ast.IndexSet) any {
+	c.compileExpression(expression.Object)
+	c.compileExpression(expression.Index)
+	c.compileExpression(expression.Value)
+
+	c.token = expression.Bracket
+	c.emitOp(OP_SET_INDEX)
+	return nil
+}
+
+func (c *Compiler) VisitSliceExpression(expression *ast.Slice) any {
+	c.compileExpression(expression.Object)
+	// Missing bounds are pushed as nil, which the slice operation reads as "from the start" or "to the end"
+	for _, bound := range []ast.Expression{expression.Start, expression.End} {
+		if bound != nil {
+			c.compileExpression(bound)
+		} else {
+			c.emitOp(OP_NIL)
+		}
+	}
+
+	c.token = expression.Bracket
+	c.emitOp(OP_SLICE)
+	return nil
+}
+
+func (c *Compiler) compileStatement(statement ast.Statement) {
+	// The parser leaves a nil behind for every declaration it had to recover from
+	if statement == nil {
+		return
+	}
+	statement.Accept(c)
+}
+
+func (c *Compiler) compileExpression(expression ast.Expression) {
+	expression.Accept(c)
+}
+
+func (c *Compiler) compileFunction(declaration *ast.FunctionStatement, kind FunctionKind) {
+	function := newCompiler(c, kind, declaration.Name.Lexeme, c.Errors)
+	function.token = declaration.Name
+	function.function.Arity = len(declaration.Params)
+
+	function.beginScope()
+	for _, param := range declaration.Params {
+		function.declareVariable(param)
+		function.markInitialized()
+	}
+	for _, statement := range declaration.Body {
+		function.compileStatement(statement)
+	}
+	function.emitReturn()
+
+	if function.hadError {
+		c.hadError = true
+	}
+
+	c.emitOpWithShort(OP_CLOSURE, c.makeConstant(function.function))
+	for _, upvalue := range function.upvalues {
+		isLocal := byte(0)
+		if upvalue.isLocal {
+			isLocal = 1
+		}
+		c.emitByte(isLocal)
+		c.emitByte(upvalue.index)
+	}
+}
+
+func (c *Compiler) namedVariable(name ast.Token) {
+	c.token = name
+	getOp, _, arg := c.resolveVariable(name)
+	c.emitVariableOp(getOp, arg)
+}
+
+// resolveVariable works out where a variable lives: a stack slot of the current function, an upvalue captured from
+// an enclosing function, or a global looked up by name.
+func (c *Compiler) resolveVariable(name ast.Token) (OpCode, OpCode, int) {
+	if slot := c.resolveLocal(name); slot != -1 {
+		return OP_GET_LOCAL, OP_SET_LOCAL, slot
+	}
+	if index := c.resolveUpvalue(name); index != -1 {
+		return OP_GET_UPVALUE, OP_SET_UPVALUE, index
+	}
+	return OP_GET_GLOBAL, OP_SET_GLOBAL, c.identifierConstant(name)
+}
+
+func (c *Compiler) emitVariableOp(op OpCode, arg int) {
+	if op == OP_GET_GLOBAL || op == OP_SET_GLOBAL {
+		c.emitOpWithShort(op, arg)
+	} else {
+		c.emitOpWithByte(op, byte(arg))
+	}
+}
+
+func (c *Compiler) resolveLocal(name ast.Token) int {
+	for i := len(c.locals) - 1; i >= 0; i-- {
+		if c.locals[i].name == name.Lexeme {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *Compiler) resolveUpvalue(name ast.Token) int {
+	if c.enclosing == nil {
+		return -1
+	}
+
+	if slot := c.enclosing.resolveLocal(name); slot != -1 {
+		c.enclosing.locals[slot].isCaptured = true
+		return c.addUpvalue(byte(slot), true, name)
+	}
+
+	if index := c.enclosing.resolveUpvalue(name); index != -1 {
+		return c.addUpvalue(byte(index), false, name)
+	}
+
+	return -1
+}
+
+func (c *Compiler) addUpvalue(index byte, isLocal bool, name ast.Token) int {
+	for i, upvalue := range c.upvalues {
+		if upvalue.index == index && upvalue.isLocal == isLocal {
+			return i
+		}
+	}
+
+	if len(c.upvalues) == maxLocals {
+		c.error(name, "too many closure variables in a function")
+		return 0
+	}
+
+	c.upvalues = append(c.upvalues, upvalue{index: index, isLocal: isLocal})
+	c.function.UpvalueCount = len(c.upvalues)
+	return len(c.upvalues) - 1
+}
+
+func (c *Compiler) declareVariable(name ast.Token) {
+	if c.scopeDepth == 0 {
+		return
+	}
+
+	if len(c.locals) == maxLocals {
+		c.error(name, "too many local variables in a function")
+		return
+	}
+	c.addLocal(name.Lexeme)
+}
+
+func (c *Compiler) addLocal(name string) {
+	// A depth of -1 marks the local as declared but not yet initialized
+	c.locals = append(c.locals, local{name: name, depth: -1})
+}
+
+func (c *Compiler) defineVariable(name ast.Token) {
+	if c.scopeDepth > 0 {
+		c.markInitialized()
+		return
+	}
+	c.emitOpWithShort(OP_DEFINE_GLOBAL, c.identifierConstant(name))
+}
+
+func (c *Compiler) markInitialized() {
+	if c.scopeDepth == 0 {
+		return
+	}
+	c.locals[len(c.locals)-1].depth = c.scopeDepth
+}
+
+// targetLoop finds the loop a break or continue jumps out of. The resolver has already checked that it exists.
+func (c *Compiler) targetLoop(label *ast.Token) *loop {
+	if label == nil {
+		return c.loops[len(c.loops)-1]
+	}
+
+	for i := len(c.loops) - 1; i >= 0; i-- {
+		if c.loops[i].label == label.Lexeme {
+			return c.loops[i]
+		}
+	}
+	return nil
+}
+
+// discardLocals pops the locals deeper than depth off the stack at runtime, without forgetting them at compile time
+// (the code after the jump still belongs to their scope).
+func (c *Compiler) discardLocals(depth int) {
+	for i := len(c.locals) - 1; i >= 0 && c.locals[i].depth > depth; i-- {
+		if c.locals[i].isCaptured {
+			c.emitOp(OP_CLOSE_UPVALUE)
+		} else {
+			c.emitOp(OP_POP)
+		}
+	}
+}
+
+func labelName(label *ast.Token) string {
+	if label == nil {
+		return ""
+	}
+	return label.Lexeme
+}
+
+func (c *Compiler) beginScope() {
+	c.scopeDepth++
+}
+
+func (c *Compiler) endScope() {
+	c.scopeDepth--
+
+	for len(c.locals) > 0 && c.locals[len(c.locals)-1].depth > c.scopeDepth {
+		if c.locals[len(c.locals)-1].isCaptured {
+			c.emitOp(OP_CLOSE_UPVALUE)
+		} else {
+			c.emitOp(OP_POP)
+		}
+		c.locals = c.locals[:len(c.locals)-1]
+	}
+}
+
+func (c *Compiler) currentChunk() *Chunk {
+	return &c.function.Chunk
+}
+
+func (c *Compiler) identifierConstant(name ast.Token) int {
+	return c.makeConstant(name.Lexeme)
+}
+
+func (c *Compiler) makeConstant(value any) int {
+	constant := c.currentChunk().AddConstant(value)
+	if constant > math.MaxUint16 {
+		c.error(c.token, "too many constants in one chunk")
+		return 0
+	}
+	return constant
+}
+
+func (c *Compiler) emitConstant(value any) {
+	c.emitOpWithShort(OP_CONSTANT, c.makeConstant(value))
+}
+
+func (c *Compiler) emitByte(b byte) {
+	c.currentChunk().Write(b, c.token)
+}
+
+func (c *Compiler) emitOp(op OpCode) {
+	c.emitByte(byte(op))
+}
+
+func (c *Compiler) emitOpWithByte(op OpCode, operand byte) {
+	c.emitOp(op)
+	c.emitByte(operand)
+}
+
+func (c *Compiler) emitOpWithShort(op OpCode, operand int) {
+	c.emitOp(op)
+	c.emitByte(byte(operand >> 8))
+	c.emitByte(byte(operand))
+}
+
+func (c *Compiler) emitJump(op OpCode) int {
+	c.emitOpWithShort(op, 0xffff)
+	return len(c.currentChunk().Code) - 2
+}
+
+func (c *Compiler) patchJump(offset int) {
+	// -2 to adjust for the jump offset itself
+	jump := len(c.currentChunk().Code) - offset - 2
+	if jump > math.MaxUint16 {
+		c.error(c.token, "too much code to jump over")
+	}
+
+	c.currentChunk().Code[offset] = byte(jump >> 8)
+	c.currentChunk().Code[offset+1] = byte(jump)
+}
+
+func (c *Compiler) emitLoop(loopStart int) {
+	c.emitOp(OP_LOOP)
+
+	offset := len(c.currentChunk().Code) - loopStart + 2
+	if offset > math.MaxUint16 {
+		c.error(c.token, "loop body too large")
+	}
+
+	c.emitByte(byte(offset >> 8))
+	c.emitByte(byte(offset))
+}
+
+func (c *Compiler) emitReturn() {
+	if c.kind == INITIALIZER {
+		c.emitOpWithByte(OP_GET_LOCAL, 0)
+	} else {
+		c.emitOp(OP_NIL)
+	}
+	c.emitOp(OP_RETURN)
+}
+
+func (c *Compiler) error(token ast.Token, message string) {
+	// Everything the compiler itself rejects is one of its limits
+	c.Errors.ReportError(errors.At(errors.COMPILE, errors.COMPILER_LIMIT, token, token.Span(), message, ""))
+	c.hadError = true
+}
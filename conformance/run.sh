@@ -0,0 +1,46 @@
+#!/bin/sh
+# Runs every conformance/*.jota script on each engine and compares its output (stdout and stderr, with colors
+# stripped) against the matching .out file. The exit status has to match too: 65 if the expected output has a syntax
+# error, 70 if it has a runtime error and 0 otherwise. Usage: conformance/run.sh [path/to/jota]
+#
+# Scripts are run from inside the conformance directory, so the file names in stack traces don't depend on where
+# this was started from.
+#
+# A script whose first line is "# flags: ..." is run with those flags, e.g. to test the limits.
+
+JOTA=${1:-./jota}
+JOTA="$(cd "$(dirname "$JOTA")" && pwd)/$(basename "$JOTA")"
+cd "$(dirname "$0")" || exit 1
+failed=0
+
+for engine in tree vm; do
+	for test in *.jota; do
+		expected="${test%.jota}.out"
+		flags=$(sed -n '1s/^# flags: //p' "$test")
+		actual=$("$JOTA" --engine=$engine $flags "$test" 2>&1; echo "status $?")
+		status=${actual##*status }
+		actual=$(printf "%s\n" "${actual%status *}" | sed 's/\x1b\[[0-9;]*m//g')
+
+		if grep -q ") Error .*->" "$expected"; then
+			expected_status=65
+		elif grep -q ") Runtime error ->" "$expected"; then
+			expected_status=70
+		else
+			expected_status=0
+		fi
+
+		if [ "$actual" != "$(cat "$expected")" ]; then
+			echo "FAIL [$engine] $test"
+			printf "%s\n" "$actual" | diff "$expected" - | sed 's/^/    /'
+			failed=1
+		elif [ "$status" != "$expected_status" ]; then
+			echo "FAIL [$engine] $test: exited with $status instead of $expected_status"
+			failed=1
+		fi
+	done
+done
+
+if [ $failed -eq 0 ]; then
+	echo "All conformance tests passed on both engines."
+fi
+exit $failed
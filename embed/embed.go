@@ -0,0 +1,210 @@
+// Package embed runs Jota from Go programs, for using it as a scripting or configuration layer. Errors come back as
+// Go errors rather than being printed.
+package embed
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"jota/builtins"
+	"jota/compiler"
+	"jota/errors"
+	"jota/interpreter"
+	"jota/modules"
+	"jota/utils"
+	"jota/vm"
+	"os"
+)
+
+// Value is anything a script can hold. Numbers are always float64, lists are []Value and maps are map[Value]Value;
+// functions, classes and instances are passed around as they are.
+type Value = any
+
+// Limits bound how much a single run may do. See LimitError for what happens when a script goes over.
+type Limits = builtins.Limits
+
+// Capabilities are what scripts may do outside their own code, like importing files. Nothing is allowed unless it's
+// granted in Options.
+type Capabilities = builtins.Capabilities
+
+// VARIADIC as an arity lets a registered function take any number of arguments.
+const VARIADIC = builtins.VARIADIC
+
+type Options struct {
+	// Engine is "tree" (the tree-walking interpreter, and the default) or "vm" (the faster bytecode VM)
+	Engine string
+	// Errors, if set, is told about every error as it happens as well, e.g. to print them
+	Errors errors.Sink
+
+	// Stdout and Stdin replace the process' streams for the script, e.g. to capture what it prints
+	Stdout io.Writer
+	Stdin  io.Reader
+	// Stderr, if set and Errors isn't, gets every error printed to it like the jota command does (without colors)
+	Stderr io.Writer
+
+	// Limits bound each Eval or RunFile on its own, e.g. Limits{MaxSteps: 1_000_000, Timeout: time.Second}
+	Limits Limits
+	// Capabilities grant scripts access to the outside world, e.g. Capabilities{Read: []string{"scripts"}} to let
+	// them import files from there. Functions added with RegisterFunction are the host's own, so they aren't checked.
+	Capabilities Capabilities
+}
+
+// Runtime is one Jota session. Globals carry over from one Eval or RunFile to the next, like in the REPL. A Runtime
+// isn't safe for concurrent use.
+type Runtime struct {
+	// Only one of these is set, depending on the engine
+	interpreter *interpreter.Interpreter
+	vm          *vm.VM
+
+	errors *collector
+}
+
+func New(options Options) (*Runtime, error) {
+	forward := options.Errors
+	if forward == nil && options.Stderr != nil {
+		forward = errors.NewErrorHandler(options.Stderr, utils.Style{})
+	}
+
+	r := &Runtime{errors: &collector{forward: forward}}
+	switch options.Engine {
+	case "", "tree":
+		r.interpreter = interpreter.NewInterpreter(r.errors, options.Capabilities)
+		r.interpreter.Limits = options.Limits
+		if options.Stdout != nil {
+			r.interpreter.Stdout = options.Stdout
+		}
+		if options.Stdin != nil {
+			r.interpreter.Stdin = options.Stdin
+		}
+	case "vm":
+		r.vm = vm.NewVM(r.errors, options.Capabilities)
+		r.vm.Limits = options.Limits
+		if options.Stdout != nil {
+			r.vm.Stdout = options.Stdout
+		}
+		if options.Stdin != nil {
+			r.vm.Stdin = options.Stdin
+		}
+	default:
+		return nil, fmt.Errorf("unknown engine %q, expected \"tree\" or \"vm\"", options.Engine)
+	}
+	return r, nil
+}
+
+// Eval runs source as if it was typed into the REPL, so imports are relative to the working directory. The error
+// is a *SyntaxError if the source can't run, a RuntimeError if it stopped on one, or a LimitError if it went over
+// one of its limits or ctx was canceled.
+func (r *Runtime) Eval(ctx context.Context, source string) error {
+	return r.run(ctx, "", source)
+}
+
+// RunFile runs a script file, returning the same errors as Eval (or the one from reading the file).
+func (r *Runtime) RunFile(ctx context.Context, path string) error {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return r.run(ctx, path, string(source))
+}
+
+func (r *Runtime) run(ctx context.Context, file, source string) error {
+	r.errors.Reset()
+	if err := ctx.Err(); err != nil {
+		// Stopped before it started, as it would have been on its first steps
+		canceled := builtins.CanceledError(err)
+		r.errors.ReportRuntimeError(canceled.RuntimeError)
+		return canceled
+	}
+
+	statements, diagnostics := modules.Check(file, source)
+	if len(diagnostics) > 0 {
+		errors.Report(diagnostics, r.errors)
+		return r.errors.err()
+	}
+
+	if r.vm != nil {
+		r.vm.File = file
+		function := compiler.Compile(statements, file, r.errors)
+		if function == nil {
+			return r.errors.err()
+		}
+		if err := r.vm.Interpret(ctx, function); err != nil {
+			return err
+		}
+		return r.errors.err()
+	}
+
+	r.interpreter.File = file
+	if err := r.interpreter.Interpret(ctx, statements); err != nil {
+		return err
+	}
+	return r.errors.err()
+}
+
+// Get reads a global variable of the main script.
+func (r *Runtime) Get(name string) (Value, bool) {
+	var value any
+	var ok bool
+	if r.vm != nil {
+		value, ok = r.vm.Global(name)
+	} else {
+		value, ok = r.interpreter.Globals.Values[name]
+	}
+	if !ok {
+		return nil, false
+	}
+	return fromJota(value), true
+}
+
+// Set defines (or overwrites) a global variable of the main script. Lists and maps are copied in, so changing them
+// afterwards doesn't affect the script. A Go map's keys go in sorted (nil, booleans, numbers, then strings), since
+// Jota maps keep their order and Go's don't have one.
+func (r *Runtime) Set(name string, value Value) error {
+	converted, err := toJota(value)
+	if err != nil {
+		return fmt.Errorf("can't set %q: %w", name, err)
+	}
+	if r.vm != nil {
+		r.vm.SetGlobal(name, converted)
+	} else {
+		r.interpreter.Globals.Define(name, converted)
+	}
+	return nil
+}
+
+// RegisterFunction makes a Go function callable from scripts and every module they import, like the built-in
+// natives. An error it returns (or a panic) becomes a runtime error at the call, which scripts can catch.
+func (r *Runtime) RegisterFunction(name string, arity int, function func(arguments []Value) (Value, error)) {
+	native := builtins.Native{Name: name, ArityNumber: arity, NativeLogic: func(engine builtins.Engine, arguments []any) any {
+		converted := make([]Value, len(arguments))
+		for i, argument := range arguments {
+			converted[i] = fromJota(argument)
+		}
+
+		result, err := callHost(name, function, converted)
+		if err != nil {
+			panic(builtins.Error{Code: errors.RUNTIME_ERROR, Message: err.Error()})
+		}
+		value, err := toJota(result)
+		if err != nil {
+			panic(builtins.Error{Code: errors.TYPE_ERROR, Message: name + "() returned a value scripts can't use: " + err.Error()})
+		}
+		return value
+	}}
+
+	if r.vm != nil {
+		r.vm.DefineNative(native)
+	} else {
+		r.interpreter.DefineNative(native)
+	}
+}
+
+// callHost calls a registered Go function, turning a panic in it into an error so it doesn't escape into the engine.
+func callHost(name string, function func(arguments []Value) (Value, error), arguments []Value) (result Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, fmt.Errorf("%s() panicked: %v", name, r)
+		}
+	}()
+	return function(arguments)
+}
@@ -0,0 +1,483 @@
+package embed_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"jota/builtins"
+	"jota/embed"
+	"jota/errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// onEachEngine runs a test once on the tree-walking interpreter and once on the VM, which have to behave the same.
+func onEachEngine(t *testing.T, test func(t *testing.T, engine string)) {
+	for _, engine := range []string{"tree", "vm"} {
+		engine := engine
+		t.Run(engine, func(t *testing.T) {
+			test(t, engine)
+		})
+	}
+}
+
+func newRuntime(t *testing.T, options embed.Options) *embed.Runtime {
+	t.Helper()
+	runtime, err := embed.New(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runtime
+}
+
+func eval(t *testing.T, runtime *embed.Runtime, source string) {
+	t.Helper()
+	if err := runtime.Eval(context.Background(), source); err != nil {
+		t.Fatalf("Eval(%q): %v", source, err)
+	}
+}
+
+func get(t *testing.T, runtime *embed.Runtime, name string) embed.Value {
+	t.Helper()
+	value, ok := runtime.Get(name)
+	if !ok {
+		t.Fatalf("Get(%q): not defined", name)
+	}
+	return value
+}
+
+func TestEval(t *testing.T) {
+	onEachEngine(t, func(t *testing.T, engine string) {
+		runtime := newRuntime(t, embed.Options{Engine: engine})
+		eval(t, runtime, `assign total = 1 + 2;`)
+		// Globals carry over from one Eval to the next
+		eval(t, runtime, `assign names = ["a", "b"]; assign ages = {"a": total};`)
+
+		if total := get(t, runtime, "total"); total != 3.0 {
+			t.Errorf("total = %v, want 3", total)
+		}
+		names, ok := get(t, runtime, "names").([]embed.Value)
+		if !ok || len(names) != 2 || names[0] != "a" || names[1] != "b" {
+			t.Errorf("names = %#v, want [a b]", names)
+		}
+		ages, ok := get(t, runtime, "ages").(map[embed.Value]embed.Value)
+		if !ok || len(ages) != 1 || ages["a"] != 3.0 {
+			t.Errorf("ages = %#v, want map[a:3]", ages)
+		}
+		if _, ok := runtime.Get("missing"); ok {
+			t.Error("Get found a global that was never defined")
+		}
+	})
+}
+
+func TestEvalErrors(t *testing.T) {
+	onEachEngine(t, func(t *testing.T, engine string) {
+		runtime := newRuntime(t, embed.Options{Engine: engine})
+
+		err := runtime.Eval(context.Background(), `assign = 1;`)
+		if _, ok := err.(*embed.SyntaxError); !ok {
+			t.Errorf("syntax error: got %#v, want a *SyntaxError", err)
+		}
+
+		err = runtime.Eval(context.Background(), "assign fine = 1;\nprint 1 + nil;")
+		e, ok := err.(embed.RuntimeError)
+		if !ok {
+			t.Fatalf("runtime error: got %#v, want a RuntimeError", err)
+		}
+		if e.Token.Line != 2 {
+			t.Errorf("runtime error on line %d, want 2", e.Token.Line)
+		}
+		// The statements before the error still ran
+		if fine := get(t, runtime, "fine"); fine != 1.0 {
+			t.Errorf("fine = %v, want 1", fine)
+		}
+	})
+}
+
+func TestSet(t *testing.T) {
+	onEachEngine(t, func(t *testing.T, engine string) {
+		runtime := newRuntime(t, embed.Options{Engine: engine})
+		config := map[string]any{"name": "jota", "sizes": []int{1, 2}}
+		if err := runtime.Set("config", config); err != nil {
+			t.Fatal(err)
+		}
+		if err := runtime.Set("count", uint8(7)); err != nil {
+			t.Fatal(err)
+		}
+		eval(t, runtime, `assign total = config["sizes"][0] + config["sizes"][1] + count; assign name = config["name"];`)
+
+		if total := get(t, runtime, "total"); total != 10.0 {
+			t.Errorf("total = %v, want 10", total)
+		}
+		if name := get(t, runtime, "name"); name != "jota" {
+			t.Errorf("name = %v, want jota", name)
+		}
+
+		// Lists and maps are copied in, so changing them afterwards doesn't reach the script
+		config["name"] = "changed"
+		eval(t, runtime, `assign name = config["name"];`)
+		if name := get(t, runtime, "name"); name != "jota" {
+			t.Errorf("name after changing the Go map = %v, want jota", name)
+		}
+
+		if err := runtime.Set("bad", map[[2]int]int{{1, 2}: 3}); err == nil {
+			t.Error("Set accepted a map whose keys scripts can't use")
+		}
+	})
+}
+
+func TestSetMapOrder(t *testing.T) {
+	onEachEngine(t, func(t *testing.T, engine string) {
+		runtime := newRuntime(t, embed.Options{Engine: engine})
+		m := map[any]int{"b": 1, "a": 2, 10: 3, 2: 4, true: 5, false: 6, nil: 7}
+		for i := 0; i < 20; i++ {
+			m["key"+strconv.Itoa(i)] = i
+		}
+		want := "[nil, false, true, 2, 10, \"a\", \"b\", \"key0\", \"key1\", \"key10\""
+
+		// Go visits a map's keys in a different order every time, but the script always gets them sorted
+		for i := 0; i < 10; i++ {
+			if err := runtime.Set("m", m); err != nil {
+				t.Fatal(err)
+			}
+			eval(t, runtime, `assign order = stringify(keys(m));`)
+			if order, _ := get(t, runtime, "order").(string); !strings.HasPrefix(order, want) {
+				t.Fatalf("keys(m) = %v, want them to start %v", order, want)
+			}
+		}
+	})
+}
+
+func TestSetAndGetCycles(t *testing.T) {
+	onEachEngine(t, func(t *testing.T, engine string) {
+		runtime := newRuntime(t, embed.Options{Engine: engine})
+		list := make([]any, 2)
+		list[0], list[1] = "first", list
+		if err := runtime.Set("list", list); err != nil {
+			t.Fatal(err)
+		}
+		eval(t, runtime, `assign inner = list[1][1][0]; assign same = list[1] == list;`)
+
+		if inner := get(t, runtime, "inner"); inner != "first" {
+			t.Errorf("inner = %v, want first", inner)
+		}
+		if same := get(t, runtime, "same"); same != true {
+			t.Errorf("the list doesn't contain itself in the script")
+		}
+
+		eval(t, runtime, `assign m = {}; m["self"] = m;`)
+		m, ok := get(t, runtime, "m").(map[embed.Value]embed.Value)
+		if !ok {
+			t.Fatalf("m isn't a map")
+		}
+		if self, ok := m["self"].(map[embed.Value]embed.Value); !ok || len(self) != 1 {
+			t.Errorf("m[self] = %#v, want m itself", m["self"])
+		}
+	})
+}
+
+func TestRegisterFunction(t *testing.T) {
+	onEachEngine(t, func(t *testing.T, engine string) {
+		runtime := newRuntime(t, embed.Options{Engine: engine})
+		runtime.RegisterFunction("add", 2, func(arguments []embed.Value) (embed.Value, error) {
+			return arguments[0].(float64) + arguments[1].(float64), nil
+		})
+		runtime.RegisterFunction("join", embed.VARIADIC, func(arguments []embed.Value) (embed.Value, error) {
+			words := make([]string, len(arguments))
+			for i, argument := range arguments {
+				words[i] = argument.(string)
+			}
+			return []string{strings.Join(words, " ")}, nil
+		})
+		eval(t, runtime, `assign sum = add(1, 2); assign joined = join("a", "b", "c")[0];`)
+
+		if sum := get(t, runtime, "sum"); sum != 3.0 {
+			t.Errorf("sum = %v, want 3", sum)
+		}
+		if joined := get(t, runtime, "joined"); joined != "a b c" {
+			t.Errorf("joined = %v, want a b c", joined)
+		}
+
+		err := runtime.Eval(context.Background(), `add(1);`)
+		if e, ok := err.(embed.RuntimeError); !ok || e.Code != errors.WRONG_ARITY {
+			t.Errorf("wrong number of arguments: got %#v, want a WRONG_ARITY error", err)
+		}
+	})
+}
+
+func TestRegisterFunctionErrors(t *testing.T) {
+	onEachEngine(t, func(t *testing.T, engine string) {
+		runtime := newRuntime(t, embed.Options{Engine: engine})
+		runtime.RegisterFunction("fail", 0, func(arguments []embed.Value) (embed.Value, error) {
+			return nil, fmt.Errorf("out of coffee")
+		})
+		runtime.RegisterFunction("explode", 0, func(arguments []embed.Value) (embed.Value, error) {
+			panic("boom")
+		})
+
+		// Both are runtime errors scripts can catch
+		eval(t, runtime, `
+assign failed = nil;
+assign exploded = nil;
+try { fail(); } catch (e) { failed = e.message; }
+try { explode(); } catch (e) { exploded = e.message; }`)
+		if failed := get(t, runtime, "failed"); failed != "out of coffee" {
+			t.Errorf("failed = %v, want the error's message", failed)
+		}
+		if exploded, _ := get(t, runtime, "exploded").(string); !strings.Contains(exploded, "explode() panicked: boom") {
+			t.Errorf("exploded = %v, want the panic's message", exploded)
+		}
+
+		err := runtime.Eval(context.Background(), `explode();`)
+		if _, ok := err.(embed.RuntimeError); !ok {
+			t.Errorf("uncaught panic: got %#v, want a RuntimeError", err)
+		}
+	})
+}
+
+func TestStdout(t *testing.T) {
+	onEachEngine(t, func(t *testing.T, engine string) {
+		var stdout bytes.Buffer
+		directory := t.TempDir()
+		runtime := newRuntime(t, embed.Options{Engine: engine, Stdout: &stdout, Capabilities: embed.Capabilities{Read: []string{directory}}})
+		eval(t, runtime, `print "hello"; print [1, "two"];`)
+		// Modules print to the same place
+		writeFile(t, filepath.Join(directory, "greeter.jota"), `print "from a module";`)
+		writeFile(t, filepath.Join(directory, "main.jota"), `import "greeter.jota" as greeter;`)
+		if err := runtime.RunFile(context.Background(), filepath.Join(directory, "main.jota")); err != nil {
+			t.Fatal(err)
+		}
+
+		if want := "hello\n[1, \"two\"]\nfrom a module\n"; stdout.String() != want {
+			t.Errorf("stdout = %q, want %q", stdout.String(), want)
+		}
+	})
+}
+
+func writeFile(t *testing.T, path, contents string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// limitError checks that err is a LimitError for limit.
+func limitError(t *testing.T, err error, limit errors.Limit) {
+	t.Helper()
+	e, ok := err.(embed.LimitError)
+	if !ok {
+		t.Fatalf("got %#v, want a LimitError", err)
+	}
+	if e.Limit != limit {
+		t.Errorf("stopped by the %q limit, want %q", e.Limit, limit)
+	}
+}
+
+// forever is a script that would never finish, and tries to catch whatever stops it.
+const forever = `
+assign caught = false;
+try {
+    while (true) {}
+} catch (e) {
+    caught = true;
+}`
+
+func TestStepLimit(t *testing.T) {
+	onEachEngine(t, func(t *testing.T, engine string) {
+		runtime := newRuntime(t, embed.Options{Engine: engine, Limits: embed.Limits{MaxSteps: 1000}})
+		limitError(t, runtime.Eval(context.Background(), forever), errors.STEP_LIMIT)
+		if caught := get(t, runtime, "caught"); caught != false {
+			t.Error("the script caught the LimitError")
+		}
+
+		// Every run gets a fresh budget
+		eval(t, runtime, `for (assign i = 0; i < 500; i = i + 1) {}`)
+	})
+}
+
+func TestTimeLimit(t *testing.T) {
+	onEachEngine(t, func(t *testing.T, engine string) {
+		runtime := newRuntime(t, embed.Options{Engine: engine, Limits: embed.Limits{Timeout: 20 * time.Millisecond}})
+		limitError(t, runtime.Eval(context.Background(), forever), errors.TIME_LIMIT)
+	})
+}
+
+func TestCanceled(t *testing.T) {
+	onEachEngine(t, func(t *testing.T, engine string) {
+		runtime := newRuntime(t, embed.Options{Engine: engine})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		time.AfterFunc(20*time.Millisecond, cancel)
+		limitError(t, runtime.Eval(ctx, forever), errors.CANCELED)
+
+		// A context that's already done stops the run before it starts, with the same error
+		limitError(t, runtime.Eval(ctx, `assign ran = true;`), errors.CANCELED)
+		if _, ok := runtime.Get("ran"); ok {
+			t.Error("the script ran with a canceled context")
+		}
+	})
+}
+
+func TestDepthLimit(t *testing.T) {
+	onEachEngine(t, func(t *testing.T, engine string) {
+		runtime := newRuntime(t, embed.Options{Engine: engine, Limits: embed.Limits{MaxDepth: 50}})
+		eval(t, runtime, `
+function down(n) {
+    if (n == 0) return 0;
+    return down(n - 1);
+}
+down(40);`)
+
+		// Unlike the other limits, it's an ordinary runtime error, which scripts can catch
+		eval(t, runtime, `
+assign message = nil;
+try { down(100); } catch (e) { message = e.message; }`)
+		if message := get(t, runtime, "message"); message != "maximum recursion depth 50 exceeded" {
+			t.Errorf("message = %v, want the depth error", message)
+		}
+
+		err := runtime.Eval(context.Background(), `down(100);`)
+		if e, ok := err.(embed.RuntimeError); !ok || e.Code != errors.STACK_OVERFLOW {
+			t.Errorf("got %#v, want a STACK_OVERFLOW error", err)
+		}
+	})
+}
+
+func TestDepthLimitIsCapped(t *testing.T) {
+	onEachEngine(t, func(t *testing.T, engine string) {
+		// Deeper than the tree engine can go without crashing, so it's brought down to MAX_DEPTH
+		runtime := newRuntime(t, embed.Options{Engine: engine, Limits: embed.Limits{MaxDepth: 1_000_000}})
+		err := runtime.Eval(context.Background(), `
+function down(n) {
+    if (n == 0) return 0;
+    return down(n - 1);
+}
+down(100000);`)
+		want := "maximum recursion depth " + strconv.Itoa(builtins.MAX_DEPTH) + " exceeded"
+		if e, ok := err.(embed.RuntimeError); !ok || e.Message != want {
+			t.Errorf("got %#v, want %q", err, want)
+		}
+	})
+}
+
+func TestMemoryLimit(t *testing.T) {
+	scripts := map[string]string{
+		"strings":   `assign s = "x"; while (true) { s = s + s; }`,
+		"lists":     `assign l = []; while (true) { l = [l, l]; }`,
+		"maps":      `while (true) { assign m = {"key": "value"}; }`,
+		"instances": `class Box {} while (true) { assign box = Box(); box.value = 1; }`,
+	}
+	onEachEngine(t, func(t *testing.T, engine string) {
+		for name, script := range scripts {
+			t.Run(name, func(t *testing.T) {
+				runtime := newRuntime(t, embed.Options{Engine: engine, Limits: embed.Limits{MaxMemory: 1 << 16}})
+				limitError(t, runtime.Eval(context.Background(), script), errors.MEMORY_LIMIT)
+			})
+		}
+	})
+}
+
+// permissionDenied checks that err is a runtime error for something the script wasn't allowed to do.
+func permissionDenied(t *testing.T, err error) {
+	t.Helper()
+	if e, ok := err.(embed.RuntimeError); !ok || e.Code != errors.PERMISSION_DENIED {
+		t.Errorf("got %#v, want a PERMISSION_DENIED error", err)
+	}
+}
+
+func TestClockCapability(t *testing.T) {
+	onEachEngine(t, func(t *testing.T, engine string) {
+		// Nothing is allowed unless it's granted
+		runtime := newRuntime(t, embed.Options{Engine: engine})
+		permissionDenied(t, runtime.Eval(context.Background(), `assign now = clock();`))
+
+		runtime = newRuntime(t, embed.Options{Engine: engine, Capabilities: embed.Capabilities{Clock: true}})
+		eval(t, runtime, `assign now = clock();`)
+	})
+}
+
+// sandbox makes allowed/, the only directory scripts will be allowed to import from, and secret/ next to it. secret/
+// is reachable from inside allowed/ through '..', a link to a file and a link to the directory.
+func sandbox(t *testing.T) (allowed, secret string) {
+	t.Helper()
+	root := t.TempDir()
+	allowed, secret = filepath.Join(root, "allowed"), filepath.Join(root, "secret")
+	for _, directory := range []string{allowed, secret} {
+		if err := os.Mkdir(directory, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, filepath.Join(allowed, "open.jota"), `assign value = "open";`)
+	writeFile(t, filepath.Join(secret, "secret.jota"), `assign value = "secret";`)
+	if err := os.Symlink(filepath.Join(secret, "secret.jota"), filepath.Join(allowed, "file_link.jota")); err != nil {
+		t.Skip("can't make symlinks here:", err)
+	}
+	if err := os.Symlink(secret, filepath.Join(allowed, "directory_link")); err != nil {
+		t.Fatal(err)
+	}
+	return allowed, secret
+}
+
+// runIn writes source to main.jota in directory and runs it.
+func runIn(t *testing.T, runtime *embed.Runtime, directory, source string) error {
+	t.Helper()
+	main := filepath.Join(directory, "main.jota")
+	writeFile(t, main, source)
+	return runtime.RunFile(context.Background(), main)
+}
+
+func TestReadCapability(t *testing.T) {
+	allowed, _ := sandbox(t)
+	onEachEngine(t, func(t *testing.T, engine string) {
+		runtime := newRuntime(t, embed.Options{Engine: engine, Capabilities: embed.Capabilities{Read: []string{allowed}}})
+		run := func(source string) error {
+			return runIn(t, runtime, allowed, source)
+		}
+
+		if err := run(`from "open.jota" import value;`); err != nil {
+			t.Fatalf("importing from the allowed directory: %v", err)
+		}
+		if value := get(t, runtime, "value"); value != "open" {
+			t.Errorf("value = %v, want open", value)
+		}
+
+		for _, path := range []string{"../secret/secret.jota", "file_link.jota", "directory_link/secret.jota"} {
+			t.Run(path, func(t *testing.T) {
+				permissionDenied(t, run(`import "`+path+`" as escaped;`))
+			})
+		}
+
+		// Without the capability, even the script's own directory is off limits
+		runtime = newRuntime(t, embed.Options{Engine: engine})
+		permissionDenied(t, run(`import "open.jota" as open;`))
+	})
+}
+
+func TestReadCapabilityHidesOtherFiles(t *testing.T) {
+	allowed, secret := sandbox(t)
+	// Modules on the search path are only looked at if the script may read them too
+	t.Setenv("JOTA_PATH", secret)
+
+	onEachEngine(t, func(t *testing.T, engine string) {
+		runtime := newRuntime(t, embed.Options{Engine: engine, Capabilities: embed.Capabilities{Read: []string{allowed}}})
+		// Each pair is a file outside allowed/ that exists and one that doesn't, which must fail the same way
+		pairs := [][2]string{
+			{"../secret/secret.jota", "../secret/missing.jota"},
+			{"directory_link/secret.jota", "directory_link/missing.jota"},
+			{"secret", "missing"},
+		}
+		for _, pair := range pairs {
+			existing := runIn(t, runtime, allowed, `import "`+pair[0]+`" as module;`)
+			missing := runIn(t, runtime, allowed, `import "`+pair[1]+`" as module;`)
+			permissionDenied(t, existing)
+			permissionDenied(t, missing)
+			if strings.Replace(existing.Error(), pair[0], pair[1], 1) != missing.Error() {
+				t.Errorf("importing %q and %q failed differently:\n%v\n%v", pair[0], pair[1], existing, missing)
+			}
+		}
+	})
+}
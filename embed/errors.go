@@ -0,0 +1,72 @@
+package embed
+
+import (
+	"jota/errors"
+	"strings"
+)
+
+// SyntaxError means the source couldn't run at all. It holds every problem that was found, including those in
+// modules it imported.
+type SyntaxError struct {
+	Diagnostics []errors.Diagnostic
+}
+
+func (e *SyntaxError) Error() string {
+	messages := make([]string, len(e.Diagnostics))
+	for i, diagnostic := range e.Diagnostics {
+		messages[i] = diagnostic.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// RuntimeError means a script stopped on an error, or on a thrown value that nothing caught (which is in Value).
+type RuntimeError = errors.RuntimeError
+
+// LimitError means a script was stopped for going over one of its Limits, or because its context was canceled. Its
+// Limit says which.
+type LimitError = errors.LimitError
+
+// collector is the Sink a Runtime gives its engine, keeping the errors from the latest run so they can be returned.
+type collector struct {
+	diagnostics []errors.Diagnostic
+	runtime     *errors.RuntimeError
+	forward     errors.Sink
+}
+
+func (c *collector) ReportError(diagnostic errors.Diagnostic) {
+	c.diagnostics = append(c.diagnostics, diagnostic)
+	if c.forward != nil {
+		c.forward.ReportError(diagnostic)
+	}
+}
+
+func (c *collector) ReportRuntimeError(error errors.RuntimeError) {
+	c.runtime = &error
+	if c.forward != nil {
+		c.forward.ReportRuntimeError(error)
+	}
+}
+
+func (c *collector) HadError() bool {
+	return len(c.diagnostics) > 0
+}
+
+func (c *collector) HadRuntimeError() bool {
+	return c.runtime != nil
+}
+
+func (c *collector) Reset() {
+	c.diagnostics, c.runtime = nil, nil
+}
+
+// err is what the latest run returns. An imported module with syntax errors fails the import at runtime too, but
+// the syntax errors are what needs fixing.
+func (c *collector) err() error {
+	if len(c.diagnostics) > 0 {
+		return &SyntaxError{Diagnostics: c.diagnostics}
+	}
+	if c.runtime != nil {
+		return *c.runtime
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package embed
+
+import (
+	"fmt"
+	"jota/builtins"
+	"reflect"
+	"sort"
+)
+
+// toJota converts a Go value into the one scripts see: any kind of number becomes a float64, and slices and maps
+// become Jota lists and maps. Anything else, like a function a script handed over earlier, passes through as it is.
+func toJota(value Value) (any, error) {
+	return toJotaValue(value, make(map[reference]any))
+}
+
+// reference identifies a Go slice or map, so one that contains itself is only converted once.
+type reference struct {
+	kind    reflect.Kind
+	pointer uintptr
+	length  int
+}
+
+func toJotaValue(value Value, converted map[reference]any) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
+	switch value := value.(type) {
+	case bool, string, float64, *builtins.List, *builtins.Map:
+		return value, nil
+	}
+
+	reflected := reflect.ValueOf(value)
+	switch reflected.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(reflected.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(reflected.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return reflected.Float(), nil
+	case reflect.Slice, reflect.Array:
+		var key reference
+		if reflected.Kind() == reflect.Slice {
+			key = reference{kind: reflect.Slice, pointer: reflected.Pointer(), length: reflected.Len()}
+			if list, ok := converted[key]; ok {
+				return list, nil
+			}
+		}
+
+		elements := make([]any, reflected.Len())
+		list := builtins.NewList(elements)
+		if key.kind != reflect.Invalid {
+			converted[key] = list
+		}
+		for i := range elements {
+			element, err := toJotaValue(reflected.Index(i).Interface(), converted)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = element
+		}
+		return list, nil
+	case reflect.Map:
+		key := reference{kind: reflect.Map, pointer: reflected.Pointer()}
+		if m, ok := converted[key]; ok {
+			return m, nil
+		}
+
+		m := builtins.NewMap()
+		converted[key] = m
+		// Go maps have no order, but Jota's do, so the keys go in sorted to keep the order the same on every run
+		type entry struct {
+			key   any
+			value reflect.Value
+		}
+		entries := make([]entry, 0, reflected.Len())
+		iterator := reflected.MapRange()
+		for iterator.Next() {
+			key, err := toJotaValue(iterator.Key().Interface(), converted)
+			if err != nil {
+				return nil, err
+			}
+			// Jota only allows immutable keys
+			switch key := key.(type) {
+			case float64:
+				if key != key {
+					return nil, fmt.Errorf("map keys can't be NaN")
+				}
+			case nil, bool, string:
+			default:
+				return nil, fmt.Errorf("map keys must be numbers, strings, booleans or nil, not %T", iterator.Key().Interface())
+			}
+			entries = append(entries, entry{key: key, value: iterator.Value()})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return keyLess(entries[i].key, entries[j].key)
+		})
+
+		for _, entry := range entries {
+			element, err := toJotaValue(entry.value.Interface(), converted)
+			if err != nil {
+				return nil, err
+			}
+			m.Set(entry.key, element)
+		}
+		return m, nil
+	}
+	return value, nil
+}
+
+// keyLess orders map keys: nil first, then false and true, then numbers and strings, each in ascending order.
+func keyLess(a, b any) bool {
+	if rankA, rankB := keyRank(a), keyRank(b); rankA != rankB {
+		return rankA < rankB
+	}
+	switch a := a.(type) {
+	case bool:
+		return !a && b.(bool)
+	case float64:
+		return a < b.(float64)
+	case string:
+		return a < b.(string)
+	}
+	return false
+}
+
+func keyRank(key any) int {
+	switch key.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case float64:
+		return 2
+	}
+	return 3
+}
+
+// fromJota converts a script's value for Go, copying lists into []Value and maps into map[Value]Value. A list or map
+// that contains itself is copied once, and the copy contains itself in the same way.
+func fromJota(value any) Value {
+	return fromJotaValue(value, make(map[any]Value))
+}
+
+func fromJotaValue(value any, converted map[any]Value) Value {
+	switch value := value.(type) {
+	case *builtins.List:
+		if elements, ok := converted[value]; ok {
+			return elements
+		}
+		elements := make([]Value, len(value.Elements))
+		converted[value] = elements
+		for i, element := range value.Elements {
+			elements[i] = fromJotaValue(element, converted)
+		}
+		return elements
+	case *builtins.Map:
+		if m, ok := converted[value]; ok {
+			return m
+		}
+		m := make(map[Value]Value, value.Len())
+		converted[value] = m
+		for _, key := range value.Keys() {
+			element, _ := value.Get(key)
+			m[key] = fromJotaValue(element, converted)
+		}
+		return m
+	}
+	return value
+}
@@ -5,19 +5,42 @@ import (
 	"jota/errors"
 )
 
+// Environment holds the variables of one scope. Global scopes keep them by name in Values, since new globals can turn
+// up at any time (from the REPL, an import or the host). Local scopes keep them in Slots instead, in the order they
+// were declared, and the resolver works out ahead of time which slot every use of a local refers to.
 type Environment struct {
 	Enclosing *Environment
 	Values    map[string]any
+	Slots     []any
 }
 
+// NewEnvironment makes a global scope, like a module's globals or the natives around them.
 func NewEnvironment(enclosing *Environment) *Environment {
 	return &Environment{Enclosing: enclosing, Values: make(map[string]any)}
 }
 
+// NewScope makes a local scope with room for the number of variables the resolver counted in it.
+func NewScope(enclosing *Environment, size int) *Environment {
+	return &Environment{Enclosing: enclosing, Slots: make([]any, 0, size)}
+}
+
+// NewScopeOf makes a local scope whose first variables are already in slots, like a function's arguments. The scope
+// takes slots over, and has room for more variables without reallocating if slots has the capacity for them.
+func NewScopeOf(enclosing *Environment, slots []any) *Environment {
+	return &Environment{Enclosing: enclosing, Slots: slots}
+}
+
+// Define adds a variable to the scope. In a local scope it takes the next slot, which is the one the resolver gave it
+// because variables are always defined in the order they're declared.
 func (e *Environment) Define(name string, value any) {
+	if e.Values == nil {
+		e.Slots = append(e.Slots, value)
+		return
+	}
 	e.Values[name] = value
 }
 
+// Get looks a global variable up by name, in this scope and the global scopes around it.
 func (e *Environment) Get(name ast.Token) any {
 	if value, ok := e.Values[name.Lexeme]; ok {
 		return value
@@ -27,12 +50,12 @@ func (e *Environment) Get(name ast.Token) any {
 		return e.Enclosing.Get(name)
 	}
 
-	panic(errors.RuntimeError{Token: name, Message: "Undefined variable '" + name.Lexeme + "'"})
+	panic(errors.RuntimeError{Token: name, Code: errors.UNDEFINED_VARIABLE, Message: "Undefined variable '" + name.Lexeme + "'"})
 }
 
 func (e *Environment) Assign(name ast.Token, value any) {
 	if _, ok := e.Values[name.Lexeme]; ok {
-		e.Define(name.Lexeme, value)
+		e.Values[name.Lexeme] = value
 		return
 	}
 
@@ -41,5 +64,22 @@ func (e *Environment) Assign(name ast.Token, value any) {
 		return
 	}
 
-	panic(errors.RuntimeError{Token: name, Message: "Undefined variable '" + name.Lexeme + "'"})
+	panic(errors.RuntimeError{Token: name, Code: errors.UNDEFINED_VARIABLE, Message: "Undefined variable '" + name.Lexeme + "'"})
+}
+
+// GetAt reads a local variable from the scope exactly 'depth' levels up, at the slot computed by the resolver.
+func (e *Environment) GetAt(depth, slot int) any {
+	return e.ancestor(depth).Slots[slot]
+}
+
+func (e *Environment) AssignAt(depth, slot int, value any) {
+	e.ancestor(depth).Slots[slot] = value
+}
+
+func (e *Environment) ancestor(depth int) *Environment {
+	environment := e
+	for i := 0; i < depth; i++ {
+		environment = environment.Enclosing
+	}
+	return environment
 }
@@ -0,0 +1,54 @@
+package errors
+
+// Code identifies a kind of error. A code never changes meaning once it has been released, so tools can match on it
+// instead of on the message, which may well get reworded.
+type Code string
+
+const (
+	// Scanning
+	UNEXPECTED_CHARACTER Code = "E0001"
+	UNTERMINATED_STRING  Code = "E0002"
+	INVALID_ESCAPE       Code = "E0003"
+	INVALID_NUMBER       Code = "E0004"
+
+	// Parsing
+	EXPECTED_TOKEN            Code = "E0101"
+	MISSING_SEMICOLON         Code = "E0102"
+	EXPECTED_EXPRESSION       Code = "E0103"
+	INVALID_ASSIGNMENT_TARGET Code = "E0104"
+	TOO_MANY_ARGUMENTS        Code = "E0105"
+	UNKNOWN_KEYWORD           Code = "E0106"
+	INVALID_LABEL             Code = "E0107"
+	INCOMPLETE_TRY            Code = "E0108"
+
+	// Resolving
+	SELF_INHERITANCE         Code = "E0201"
+	TOP_LEVEL_RETURN         Code = "E0202"
+	INITIALIZER_RETURN       Code = "E0203"
+	NESTED_IMPORT            Code = "E0204"
+	SUPER_OUTSIDE_CLASS      Code = "E0205"
+	SUPER_WITHOUT_SUPERCLASS Code = "E0206"
+	THIS_OUTSIDE_CLASS       Code = "E0207"
+	SELF_INITIALIZER         Code = "E0208"
+	JUMP_OUTSIDE_LOOP        Code = "E0209"
+	UNKNOWN_LABEL            Code = "E0210"
+	DUPLICATE_VARIABLE       Code = "E0211"
+
+	// Compiling
+	COMPILER_LIMIT Code = "E0301"
+
+	// Running. RUNTIME_ERROR is for anything without a more specific code
+	RUNTIME_ERROR      Code = "E1000"
+	UNDEFINED_VARIABLE Code = "E1001"
+	TYPE_ERROR         Code = "E1002"
+	WRONG_ARITY        Code = "E1003"
+	UNDEFINED_PROPERTY Code = "E1004"
+	INDEX_OUT_OF_RANGE Code = "E1005"
+	KEY_NOT_FOUND      Code = "E1006"
+	INVALID_VALUE      Code = "E1007"
+	STACK_OVERFLOW     Code = "E1008"
+	UNCAUGHT_THROW     Code = "E1009"
+	IMPORT_FAILED      Code = "E1010"
+	LIMIT_EXCEEDED     Code = "E1011"
+	PERMISSION_DENIED  Code = "E1012"
+)
@@ -0,0 +1,57 @@
+package errors
+
+import (
+	"jota/ast"
+	"strings"
+)
+
+// Kind is the phase that found a problem.
+type Kind int
+
+const (
+	SCAN Kind = iota
+	PARSE
+	RESOLVE
+	COMPILE
+)
+
+func (k Kind) String() string {
+	switch k {
+	case SCAN:
+		return "scan"
+	case PARSE:
+		return "parse"
+	case RESOLVE:
+		return "resolve"
+	default:
+		return "compile"
+	}
+}
+
+// Diagnostic is a problem found in the source before it runs. The phases collect these rather than printing them
+// straight away, so a caller can report every problem in a file at once.
+type Diagnostic struct {
+	Kind Kind
+	Code Code
+	Span ast.Span
+	// Where names what the problem was found at, like "at 'print'" or "at end", and is empty if there's nothing to name
+	Where   string
+	Message string
+	// Help suggests a fix, if there's an obvious one
+	Help string
+}
+
+// Error lets a diagnostic be handed around as a Go error, which is how programs embedding Jota see them.
+func (d Diagnostic) Error() string {
+	return strings.TrimPrefix(location(d.Span), ":") + ": " + d.Message
+}
+
+// At is a diagnostic found at token, underlining span (which need not be the token's own, e.g. to point just after
+// it).
+func At(kind Kind, code Code, token ast.Token, span ast.Span, message, help string) Diagnostic {
+	where := "at '" + token.Lexeme + "'"
+	if token.Type == ast.EOF {
+		where = "at end"
+	}
+	return Diagnostic{Kind: kind, Code: code, Span: span, Where: where, Message: message, Help: help}
+}
@@ -1,44 +1,221 @@
 package errors
 
 import (
+	"io"
 	"jota/ast"
 	"jota/utils"
 	"log"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
+// Sink is where errors end up. Every phase reports to the same one, so whoever ran them can tell afterwards whether
+// anything went wrong.
+type Sink interface {
+	ReportError(diagnostic Diagnostic)
+	ReportRuntimeError(error RuntimeError)
+	HadError() bool
+	HadRuntimeError() bool
+	// Reset forgets about earlier errors, so the REPL can carry on after a bad line
+	Reset()
+}
+
+// ErrorHandler is the Sink that prints errors for people to read.
 type ErrorHandler struct {
-	Error        bool
-	RuntimeError bool
-	Log          log.Logger
+	Log   *log.Logger
+	Style utils.Style
+
+	hadError, hadRuntimeError bool
 }
 
-func Err(token ast.Token, message string, handler ErrorHandler) {
-	switch token.Type {
-	case ast.EOF:
-		report(token.Line, "at end", message, handler)
-	default:
-		report(token.Line, "at '"+token.Lexeme+"'", message, handler)
-	}
+func NewErrorHandler(out io.Writer, style utils.Style) *ErrorHandler {
+	return &ErrorHandler{Log: log.New(out, "", 0), Style: style}
 }
 
-func ErrWithoutToken(line int, message string, handler ErrorHandler) {
-	report(line, "", message, handler)
+func (h *ErrorHandler) HadError() bool {
+	return h.hadError
+}
+
+func (h *ErrorHandler) HadRuntimeError() bool {
+	return h.hadRuntimeError
+}
+
+func (h *ErrorHandler) Reset() {
+	h.hadError, h.hadRuntimeError = false, false
+}
+
+// Report hands every diagnostic to the sink, in the order they were found.
+func Report(diagnostics []Diagnostic, sink Sink) {
+	for _, diagnostic := range diagnostics {
+		sink.ReportError(diagnostic)
+	}
 }
 
 type RuntimeError struct {
-	Token   ast.Token
+	Token ast.Token
+	// Code is RUNTIME_ERROR if left empty
+	Code    Code
 	Message string
+	// Value is what a throw statement threw, or nil for errors raised by the runtime itself
+	Value any
+	// Stack lists the calls that were running when the error was raised, outermost first
+	Stack []StackFrame
+}
+
+func (e RuntimeError) Error() string {
+	return strings.TrimPrefix(location(e.Token.Span()), ":") + ": " + e.Message
+}
+
+// Limit is one of the limits a host can put on a run, named in the LimitError that stops a script going over it.
+type Limit string
+
+const (
+	STEP_LIMIT   Limit = "steps"
+	TIME_LIMIT   Limit = "time"
+	MEMORY_LIMIT Limit = "memory"
+	// CANCELED means the host canceled the run's context (or its deadline passed)
+	CANCELED Limit = "canceled"
+)
+
+// LimitError stops a script that went over one of its limits. Unlike a RuntimeError, try blocks don't catch it, so a
+// script can't keep itself running; it always reaches the host.
+type LimitError struct {
+	RuntimeError
+	Limit Limit
+}
+
+// StackFrame is one call on the stack: the function being run, and the line (and file, if any) it had reached.
+type StackFrame struct {
+	Function string
+	Line     int
+	File     string
+}
+
+func (sf StackFrame) String() string {
+	if sf.File == "" {
+		return "line " + strconv.Itoa(sf.Line) + ", in " + sf.Function
+	}
+	return displayPath(sf.File) + ":" + strconv.Itoa(sf.Line) + ", in " + sf.Function
+}
+
+// displayPath shortens paths inside the working directory (modules are loaded by their absolute path).
+func displayPath(path string) string {
+	if !filepath.IsAbs(path) {
+		return path
+	}
+	if dir, err := os.Getwd(); err == nil {
+		if relative, err := filepath.Rel(dir, path); err == nil && !strings.HasPrefix(relative, "..") {
+			return relative
+		}
+	}
+	return path
+}
+
+// traceback formats a call stack for an error report, most recent call last. Runs of the same frame (from
+// recursion) are cut short after a few lines.
+func traceback(stack []StackFrame) string {
+	const maxRepeats = 3
+
+	lines := []string{"  Traceback (most recent call last):"}
+	for i := 0; i < len(stack); {
+		repeats := 1
+		for i+repeats < len(stack) && stack[i+repeats] == stack[i] {
+			repeats++
+		}
+
+		for j := 0; j < repeats && j < maxRepeats; j++ {
+			lines = append(lines, "    "+stack[i].String())
+		}
+		if repeats > maxRepeats {
+			lines = append(lines, "    [previous line repeated "+strconv.Itoa(repeats-maxRepeats)+" more times]")
+		}
+		i += repeats
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (h *ErrorHandler) ReportRuntimeError(error RuntimeError) {
+	span := error.Token.Span()
+	h.Log.Println(h.Style.Paint(utils.ERROR, "("+location(span)+") Runtime error ->") + " " + h.Style.Paint(utils.TEXT, error.Message))
+	if quote := h.snippet(span); quote != "" {
+		h.Log.Println(quote)
+	}
+	// An error raised straight from the top level has nothing to add to the lines above
+	if len(error.Stack) > 1 {
+		h.Log.Println(traceback(error.Stack))
+	}
+	h.hadRuntimeError = true
+}
+
+func (h *ErrorHandler) ReportError(diagnostic Diagnostic) {
+	where := ""
+	if diagnostic.Where != "" {
+		where = diagnostic.Where + " "
+	}
+	h.Log.Println(h.Style.Paint(utils.ERROR, "("+location(diagnostic.Span)+") Error "+where+"->") + " " + h.Style.Paint(utils.TEXT, diagnostic.Message))
+	if quote := h.snippet(diagnostic.Span); quote != "" {
+		h.Log.Println(quote)
+	}
+	if diagnostic.Help != "" {
+		h.Log.Println(h.Style.Paint(utils.HINT, "  help:") + " " + diagnostic.Help)
+	}
+	h.hadError = true
 }
 
-func RuntimeErr(error RuntimeError, handler ErrorHandler) {
-	strLine := strconv.Itoa(error.Token.Line)
-	handler.Log.Println(utils.Red + "(:" + strLine + ") Runtime error ->" + utils.White + " " + error.Message + utils.Reset)
-	handler.RuntimeError = true
+// location is "file:line:column", with no file for code from the REPL and no column for tokens that were made up
+// rather than scanned.
+func location(span ast.Span) string {
+	name := ""
+	if span.Source != nil {
+		name = displayPath(span.Source.Name)
+	}
+	if span.Column == 0 {
+		return name + ":" + strconv.Itoa(span.Line)
+	}
+	return name + ":" + strconv.Itoa(span.Line) + ":" + strconv.Itoa(span.Column)
 }
 
-func report(line int, where, message string, handler ErrorHandler) {
-	strLine := strconv.Itoa(line)
-	handler.Log.Println(utils.Red + "(:" + strLine + ") Error " + where + " ->" + utils.White + " " + message + utils.Reset)
-	handler.Error = true
+// snippet quotes the source line span starts on, with carets under the span. A span running over several lines is
+// only underlined up to the end of the first.
+func (h *ErrorHandler) snippet(span ast.Span) string {
+	if span.Source == nil || span.Column == 0 || span.Offset > len(span.Source.Text) {
+		return ""
+	}
+	text := span.Source.Text
+
+	start := strings.LastIndexByte(text[:span.Offset], '\n') + 1
+	end := strings.IndexByte(text[span.Offset:], '\n')
+	if end == -1 {
+		end = len(text)
+	} else {
+		end += span.Offset
+	}
+	line := strings.TrimRight(text[start:end], "\r")
+
+	// Tabs are kept so the carets line up however wide the terminal draws them
+	var padding strings.Builder
+	for _, char := range text[start:span.Offset] {
+		if char == '\t' {
+			padding.WriteRune('\t')
+		} else {
+			padding.WriteRune(' ')
+		}
+	}
+
+	underlined := span.Offset + span.Length
+	if underlined > start+len(line) {
+		underlined = start + len(line)
+	}
+	width := 1
+	if underlined > span.Offset {
+		width = utf8.RuneCountInString(text[span.Offset:underlined])
+	}
+
+	number := strconv.Itoa(span.Line)
+	gutter := strings.Repeat(" ", len(number))
+	return h.Style.Paint(utils.HINT, "  "+number+" |") + " " + line + "\n" +
+		h.Style.Paint(utils.HINT, "  "+gutter+" |") + " " + padding.String() + h.Style.Paint(utils.ERROR, strings.Repeat("^", width))
 }
@@ -0,0 +1,94 @@
+package errors
+
+import (
+	"encoding/json"
+	"io"
+	"jota/ast"
+)
+
+// JSONHandler is the Sink for editors and CI: it writes every error as a JSON object on a line of its own.
+type JSONHandler struct {
+	encoder *json.Encoder
+
+	hadError, hadRuntimeError bool
+}
+
+type jsonError struct {
+	Severity string `json:"severity"`
+	Code     Code   `json:"code"`
+	// Kind is the phase that found the error: scan, parse, resolve, compile or runtime
+	Kind      string      `json:"kind"`
+	Message   string      `json:"message"`
+	Help      string      `json:"help,omitempty"`
+	File      string      `json:"file"`
+	Line      int         `json:"line"`
+	Column    int         `json:"column"`
+	EndLine   int         `json:"endLine"`
+	EndColumn int         `json:"endColumn"`
+	Stack     []jsonFrame `json:"stack,omitempty"`
+}
+
+type jsonFrame struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+}
+
+func NewJSONHandler(out io.Writer) *JSONHandler {
+	encoder := json.NewEncoder(out)
+	// Messages quote code, which is full of '<', '>' and '&'
+	encoder.SetEscapeHTML(false)
+	return &JSONHandler{encoder: encoder}
+}
+
+func (h *JSONHandler) HadError() bool {
+	return h.hadError
+}
+
+func (h *JSONHandler) HadRuntimeError() bool {
+	return h.hadRuntimeError
+}
+
+func (h *JSONHandler) Reset() {
+	h.hadError, h.hadRuntimeError = false, false
+}
+
+func (h *JSONHandler) ReportError(diagnostic Diagnostic) {
+	h.write(positioned(diagnostic.Span, jsonError{
+		Code: diagnostic.Code, Kind: diagnostic.Kind.String(), Message: diagnostic.Message, Help: diagnostic.Help,
+	}))
+	h.hadError = true
+}
+
+func (h *JSONHandler) ReportRuntimeError(error RuntimeError) {
+	code := error.Code
+	if code == "" {
+		code = RUNTIME_ERROR
+	}
+	entry := positioned(error.Token.Span(), jsonError{Code: code, Kind: "runtime", Message: error.Message})
+	for _, frame := range error.Stack {
+		file := frame.File
+		if file != "" {
+			file = displayPath(file)
+		}
+		entry.Stack = append(entry.Stack, jsonFrame{Function: frame.Function, File: file, Line: frame.Line})
+	}
+	h.write(entry)
+	h.hadRuntimeError = true
+}
+
+// positioned fills in where an error is. Errors from made-up tokens have no column, and so report 0.
+func positioned(span ast.Span, entry jsonError) jsonError {
+	entry.Severity = "error"
+	if span.Source != nil && span.Source.Name != "" {
+		entry.File = displayPath(span.Source.Name)
+	}
+	entry.Line, entry.Column = span.Line, span.Column
+	entry.EndLine, entry.EndColumn = span.End()
+	return entry
+}
+
+func (h *JSONHandler) write(entry jsonError) {
+	// Writing to stderr isn't expected to fail, and there would be nowhere left to report it if it did
+	_ = h.encoder.Encode(entry)
+}
@@ -0,0 +1,79 @@
+package interpreter
+
+import (
+	"jota/ast"
+	"jota/builtins"
+	"jota/errors"
+)
+
+type Class struct {
+	Name       string
+	Superclass *Class
+	Methods    map[string]Function
+}
+
+func (c *Class) FindMethod(name string) (Function, bool) {
+	if method, ok := c.Methods[name]; ok {
+		return method, true
+	}
+
+	if c.Superclass != nil {
+		return c.Superclass.FindMethod(name)
+	}
+
+	return Function{}, false
+}
+
+func (c *Class) Call(interpreter *Interpreter, arguments []any) any {
+	// Like a map, as that's what holds its fields
+	interpreter.allocate(*interpreter.call, builtins.COLLECTION_SIZE)
+	instance := &Instance{Class: c, Fields: make(map[string]any)}
+	if initializer, ok := c.FindMethod("init"); ok {
+		initializer.Bind(instance).Call(interpreter, arguments)
+	}
+	return instance
+}
+
+func (c *Class) Arity() int {
+	if initializer, ok := c.FindMethod("init"); ok {
+		return initializer.Arity()
+	}
+	return 0
+}
+
+func (c *Class) TypeName() string {
+	return "class"
+}
+
+func (c *Class) String() string {
+	return "<class " + c.Name + ">"
+}
+
+type Instance struct {
+	Class  *Class
+	Fields map[string]any
+}
+
+func (in *Instance) Get(name ast.Token) any {
+	if value, ok := in.Fields[name.Lexeme]; ok {
+		return value
+	}
+
+	if method, ok := in.Class.FindMethod(name.Lexeme); ok {
+		return method.Bind(in)
+	}
+
+	panic(errors.RuntimeError{Token: name, Code: errors.UNDEFINED_PROPERTY, Message: "undefined property '" + name.Lexeme + "'"})
+}
+
+func (in *Instance) Set(name ast.Token, value any) {
+	in.Fields[name.Lexeme] = value
+}
+
+func (in *Instance) TypeName() string {
+	return "instance"
+}
+
+func (in *Instance) String() string {
+	return "<" + in.Class.Name + " instance>"
+}
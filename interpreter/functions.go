@@ -2,6 +2,7 @@ package interpreter
 
 import (
 	"jota/ast"
+	"jota/builtins"
 	"jota/environment"
 )
 
@@ -11,46 +12,76 @@ type Callable interface {
 }
 
 type Function struct {
-	Declaration ast.FunctionStatement
+	Declaration *ast.FunctionStatement
 	Closure     *environment.Environment
+	// Globals is the global scope of the module the function was declared in, which its global names refer to
+	Globals *environment.Environment
+	// File is where the function was declared, for stack traces
+	File          string
+	IsInitializer bool
 }
 
-func (f Function) Call(interpreter *Interpreter, arguments []any) (value any) {
-	defer func() {
-		if r := recover(); r != nil {
-			if e, ok := r.(Return); ok {
-				value = e.Value
-			}
-		}
-	}()
+func (f Function) Call(interpreter *Interpreter, arguments []any) any {
+	// An error leaves the frame behind, for whatever recovers it to pop
+	interpreter.pushFrame(f.Declaration.Name.Lexeme)
+	interpreter.Globals, interpreter.File = f.Globals, f.File
 
-	env := environment.NewEnvironment(f.Closure)
-	for i, param := range f.Declaration.Params {
-		env.Define(param.Lexeme, arguments[i])
+	// The parameters are the first slots, and every caller hands over a slice of arguments of its own
+	signal := interpreter.executeBlock(f.Declaration.Body, environment.NewScopeOf(f.Closure, arguments))
+	interpreter.popFrame()
+
+	// Initializers always hand back the instance, even on an early 'return;'
+	if f.IsInitializer {
+		return f.Closure.GetAt(0, 0)
+	}
+	if _, ok := signal.(Return); ok {
+		returned := interpreter.returned
+		interpreter.returned = nil
+		return returned
 	}
-	interpreter.executeBlock(f.Declaration.Body, env)
 	return nil
 }
 
+// Bind returns a copy of the method whose closure has 'this' set to the given instance.
+func (f Function) Bind(instance *Instance) Function {
+	env := environment.NewScope(f.Closure, 1)
+	env.Define("this", instance)
+	return Function{Declaration: f.Declaration, Closure: env, Globals: f.Globals, File: f.File, IsInitializer: f.IsInitializer}
+}
+
 func (f Function) Arity() int {
 	return len(f.Declaration.Params)
 }
 
+func (f Function) TypeName() string {
+	return "function"
+}
+
 func (f Function) String() string {
 	return "<fn " + f.Declaration.Name.Lexeme + ">"
 }
 
+// BuiltInFunction is always used through a pointer, so natives compare (and hash) by identity as they do in the VM.
 type BuiltInFunction struct {
 	ArityNumber int
 	NativeLogic func(interpreter *Interpreter, arguments []any) any
 }
 
-func (bif BuiltInFunction) Call(interpreter *Interpreter, arguments []any) any {
+func (bif *BuiltInFunction) Call(interpreter *Interpreter, arguments []any) any {
 	return bif.NativeLogic(interpreter, arguments)
 }
-func (bif BuiltInFunction) Arity() int {
+func (bif *BuiltInFunction) Arity() int {
 	return bif.ArityNumber
 }
-func (bif BuiltInFunction) String() string {
+func (bif *BuiltInFunction) TypeName() string {
+	return "function"
+}
+func (bif *BuiltInFunction) String() string {
 	return "<native fn>"
 }
+
+func wrapNative(native builtins.Native) func(interpreter *Interpreter, arguments []any) any {
+	return func(interpreter *Interpreter, arguments []any) any {
+		return native.NativeLogic(interpreter, arguments)
+	}
+}
@@ -1,71 +1,101 @@
 package interpreter
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"jota/ast"
+	"jota/builtins"
 	"jota/environment"
 	"jota/errors"
+	"jota/modules"
 	"math"
+	"os"
 	"strings"
-	"time"
 )
 
 type Interpreter struct {
-	Globals      *environment.Environment
-	Environment  *environment.Environment
-	ErrorHandler errors.ErrorHandler
+	Globals     *environment.Environment
+	Environment *environment.Environment
+	Errors      errors.Sink
+	// File is the path of the file being run ("" in the REPL), which imports are resolved relative to
+	File string
+	// Where print writes to and input would read from, the process' own streams by default. Errors go to the Sink.
+	Stdout io.Writer
+	Stdin  io.Reader
+	// Limits apply to every run from now on, each one getting a fresh budget
+	Limits builtins.Limits
+
+	// Every module gets its own globals, all enclosing this shared scope of natives
+	natives      *environment.Environment
+	modules      *modules.Loader
+	capabilities builtins.Capabilities
+
+	// The script functions currently running, and the call being made right now. An error leaves the frames and
+	// environments it unwinds through in place, so its stack can be recorded wherever it's caught, which is also where
+	// they're restored.
+	frames []callFrame
+	call   *ast.Token
+	budget *builtins.Budget
+	// The value of the return statement on its way to the call it returns from
+	returned any
 }
 
-func NewInterpreter(errorHandler errors.ErrorHandler) *Interpreter {
-	globals := environment.NewEnvironment(nil)
+// callFrame is a running script function, and the call, file and globals it was called from.
+type callFrame struct {
+	function string
+	call     *ast.Token
+	file     string
+	globals  *environment.Environment
+}
 
-	// TODO: put these in a separate file!
-	globals.Define("clock", BuiltInFunction{
-		ArityNumber: 0,
-		NativeLogic: func(interpreter *Interpreter, arguments []any) any {
-			return float64(time.Now().UnixNano()) / 1e9 // Returns the elapsed time in seconds.
-		},
-	})
-	globals.Define("milliseconds", BuiltInFunction{
-		ArityNumber: 1,
-		NativeLogic: func(interpreter *Interpreter, arguments []any) any {
-			value, ok := arguments[0].(float64)
-			if !ok {
-				return nil
-			}
-			return float64(math.Round(value*1000*100) / 100)
-		},
-	})
-	globals.Define("stringify", BuiltInFunction{
-		ArityNumber: 1,
-		NativeLogic: func(interpreter *Interpreter, arguments []any) any {
-			return fmt.Sprint(arguments[0])
-		},
-	})
-	globals.Define("type", BuiltInFunction{
-		ArityNumber: 1,
-		NativeLogic: func(interpreter *Interpreter, arguments []any) any {
-			if fmt.Sprintf("%T", arguments[0]) == "float64" {
-				return "number"
-			} else {
-				return fmt.Sprintf("%T", arguments[0])
-			}
-		},
-	})
+// NewInterpreter makes an interpreter whose scripts can only do what capabilities allows, like reading files.
+func NewInterpreter(sink errors.Sink, capabilities builtins.Capabilities) *Interpreter {
+	natives := environment.NewEnvironment(nil)
+	globals := environment.NewEnvironment(natives)
 
-	return &Interpreter{
+	interpreter := &Interpreter{
 		Globals:      globals,
 		Environment:  globals,
-		ErrorHandler: errorHandler,
+		Errors:       sink,
+		Stdout:       os.Stdout,
+		Stdin:        os.Stdin,
+		call:         &ast.Token{},
+		natives:      natives,
+		modules:      modules.NewLoader(capabilities),
+		capabilities: capabilities,
 	}
+	for _, native := range builtins.Natives {
+		interpreter.DefineNative(native)
+	}
+	return interpreter
+}
+
+// DefineNative makes a native function visible to the main script and every module, like the built-in ones. If it
+// needs a capability the interpreter wasn't given, calling it is a permission error.
+func (i *Interpreter) DefineNative(native builtins.Native) {
+	native = i.capabilities.Guard(native)
+	i.natives.Define(native.Name, &BuiltInFunction{ArityNumber: native.ArityNumber, NativeLogic: wrapNative(native)})
 }
 
-func (i *Interpreter) Interpret(statements []ast.Statement) {
+// Interpret runs statements, reporting a runtime error to the Sink if one stops them. Going over a limit or ctx
+// being canceled is reported too, and also returned (as a LimitError) because it's the host's doing, not the script's.
+func (i *Interpreter) Interpret(ctx context.Context, statements []ast.Statement) (err error) {
+	i.budget = i.Limits.Start(ctx)
+	checkpoint := i.checkpoint()
 	defer func() {
 		if r := recover(); r != nil {
-			if e, ok := r.(errors.RuntimeError); ok {
-				errors.RuntimeErr(e, i.ErrorHandler)
-				return
+			r = i.stamped(r)
+			i.restore(checkpoint)
+			switch e := r.(type) {
+			case errors.RuntimeError:
+				i.Errors.ReportRuntimeError(e)
+			case errors.LimitError:
+				i.Errors.ReportRuntimeError(e.RuntimeError)
+				err = e
+			default:
+				// Anything else is a bug in the interpreter, which mustn't look like the script just finished
+				panic(r)
 			}
 		}
 	}()
@@ -73,29 +103,53 @@ func (i *Interpreter) Interpret(statements []ast.Statement) {
 	for _, statement := range statements {
 		i.execute(statement)
 	}
-	return
+	return nil
+}
+
+// step counts a loop iteration or call against the budget, stopping the script at token if it's over.
+func (i *Interpreter) step(token ast.Token) {
+	overBudget(i.budget.Step(), token)
+}
+
+// allocate counts the memory for a value about to be made at token, stopping the script if it's over the limit.
+func (i *Interpreter) allocate(token ast.Token, bytes int) {
+	overBudget(i.budget.Allocate(bytes), token)
+}
+
+// Allocate is allocate for natives. The native's call expression fills in the token.
+func (i *Interpreter) Allocate(bytes int) {
+	i.allocate(ast.Token{}, bytes)
+}
+
+func overBudget(exceeded *errors.LimitError, token ast.Token) {
+	if exceeded != nil {
+		e := *exceeded
+		e.Token = token
+		panic(e)
+	}
 }
 
-func (i *Interpreter) VisitIfStatement(statement ast.IfStatement) any {
+func (i *Interpreter) VisitIfStatement(statement *ast.IfStatement) any {
 	if i.isTruthy(i.evaluate(statement.Condition)) {
-		i.execute(statement.ThenBranch)
+		return i.execute(statement.ThenBranch)
 	} else if statement.ElseBranch != nil {
-		i.execute(statement.ElseBranch)
+		return i.execute(statement.ElseBranch)
 	}
 	return nil
 }
 
-func (i *Interpreter) VisitExpressionStatement(statement ast.ExpressionStatement) any {
-	return i.evaluate(statement.Expression)
+func (i *Interpreter) VisitExpressionStatement(statement *ast.ExpressionStatement) any {
+	i.evaluate(statement.Expression)
+	return nil
 }
 
-func (i *Interpreter) VisitPrintStatement(statement ast.PrintStatement) any {
+func (i *Interpreter) VisitPrintStatement(statement *ast.PrintStatement) any {
 	value := i.evaluate(statement.Expression)
-	fmt.Println(i.stringify(value))
+	fmt.Fprintln(i.Stdout, i.stringify(value))
 	return nil
 }
 
-func (i *Interpreter) VisitVariableStatement(statement ast.VariableStatement) any {
+func (i *Interpreter) VisitVariableStatement(statement *ast.VariableStatement) any {
 	var value any
 	if statement.Initializer != nil {
 		value = i.evaluate(statement.Initializer)
@@ -105,42 +159,338 @@ func (i *Interpreter) VisitVariableStatement(statement ast.VariableStatement) an
 	return nil
 }
 
-func (i *Interpreter) VisitWhileStatement(statement ast.WhileStatement) any {
+func (i *Interpreter) VisitWhileStatement(statement *ast.WhileStatement) any {
 	for i.isTruthy(i.evaluate(statement.Condition)) {
-		i.execute(statement.Body)
+		if done, signal := loopSignal(statement.Label, i.execute(statement.Body)); done {
+			return signal
+		}
+		i.step(statement.Keyword)
+
+		if statement.Increment != nil {
+			i.evaluate(statement.Increment)
+		}
 	}
 	return nil
 }
 
-func (i *Interpreter) VisitBlockStatement(statement ast.BlockStatement) any {
-	i.executeBlock(statement.Statements, environment.NewEnvironment(i.Environment))
+func (i *Interpreter) VisitBreakStatement(statement *ast.BreakStatement) any {
+	return Break{Label: labelName(statement.Label)}
+}
+
+func (i *Interpreter) VisitContinueStatement(statement *ast.ContinueStatement) any {
+	return Continue{Label: labelName(statement.Label)}
+}
+
+// loopSignal decides what a loop does after a pass of its body, which finished with signal. It returns true if the
+// loop should stop, along with the signal the loop statement itself should finish with.
+func loopSignal(label *ast.Token, signal any) (bool, any) {
+	switch signal := signal.(type) {
+	case nil:
+		return false, nil
+	case Break:
+		if signal.Label == "" || signal.Label == labelName(label) {
+			return true, nil
+		}
+	case Continue:
+		if signal.Label == "" || signal.Label == labelName(label) {
+			return false, nil
+		}
+	}
+	// Returns and jumps aimed at an outer loop carry on outwards
+	return true, signal
+}
+
+func labelName(label *ast.Token) string {
+	if label == nil {
+		return ""
+	}
+	return label.Lexeme
+}
+
+func (i *Interpreter) VisitForInStatement(statement *ast.ForInStatement) any {
+	iterable := i.evaluate(statement.Iterable)
+	single := len(statement.Variables) == 1
+	iterator := i.native(statement.In, func() any {
+		return builtins.Iterate(iterable, single)
+	}).(builtins.Iterator)
+
+	body := []ast.Statement{statement.Body}
+	for {
+		key, value, ok := iterator.Next()
+		if !ok {
+			break
+		}
+
+		// Every iteration gets its own variables, so closures made in the body don't all see the last element
+		env := environment.NewScope(i.Environment, len(statement.Variables))
+		if single {
+			env.Define(statement.Variables[0].Lexeme, value)
+		} else {
+			env.Define(statement.Variables[0].Lexeme, key)
+			env.Define(statement.Variables[1].Lexeme, value)
+		}
+
+		if done, signal := loopSignal(statement.Label, i.executeBlock(body, env)); done {
+			return signal
+		}
+		i.step(statement.In)
+	}
+	return nil
+}
+
+func (i *Interpreter) VisitThrowStatement(statement *ast.ThrowStatement) any {
+	panic(builtins.Thrown(statement.Keyword, i.evaluate(statement.Value)))
+}
+
+func (i *Interpreter) VisitTryStatement(statement *ast.TryStatement) (signal any) {
+	if statement.Finally != nil {
+		checkpoint := i.checkpoint()
+		// Deferred, so it also runs when an error leaves the try or catch block
+		defer func() {
+			r := recover()
+			// Going over a limit stops the script right away, without running any more of it, as in the VM
+			if e, ok := r.(errors.LimitError); ok {
+				panic(e)
+			}
+			if r != nil {
+				// The finally block runs in the function the try is in, not wherever the error came from
+				r = i.stamped(r)
+				i.restore(checkpoint)
+			}
+
+			// A return, break or continue in the finally block wins over whatever was leaving, even an error. Otherwise,
+			// whatever the try block was returning has to survive the calls in the finally block.
+			returned := i.returned
+			if finally := i.VisitBlockStatement(statement.Finally); finally != nil {
+				signal = finally
+				return
+			}
+			i.returned = returned
+			if r != nil {
+				panic(r)
+			}
+		}()
+	}
+
+	return i.tryBlock(statement)
+}
+
+func (i *Interpreter) tryBlock(statement *ast.TryStatement) (signal any) {
+	if statement.Catch == nil {
+		return i.execute(statement.Body)
+	}
+
+	checkpoint := i.checkpoint()
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		// Going over a limit isn't an error scripts can catch
+		e, ok := r.(errors.RuntimeError)
+		if !ok {
+			panic(r)
+		}
+		e = i.withStack(e)
+		i.restore(checkpoint)
+
+		env := environment.NewScope(i.Environment, statement.Catch.Size)
+		if statement.CatchName != nil {
+			env.Define(statement.CatchName.Lexeme, builtins.Caught(e))
+		}
+		signal = i.executeBlock(statement.Catch.Statements, env)
+	}()
+
+	return i.execute(statement.Body)
+}
+
+// withStack records the call stack on an error that doesn't have one yet. It has to happen before the frames the
+// error came through are unwound.
+func (i *Interpreter) withStack(e errors.RuntimeError) errors.RuntimeError {
+	if e.Stack != nil {
+		return e
+	}
+
+	// Each frame records where its caller was, so the innermost frame's position comes from the error itself
+	e.Stack = make([]errors.StackFrame, len(i.frames)+1)
+	e.Stack[0].Function = "<script>"
+	for index, frame := range i.frames {
+		e.Stack[index].Line = frame.call.Line
+		e.Stack[index].File = frame.file
+		e.Stack[index+1].Function = frame.function
+	}
+	e.Stack[len(i.frames)].Line = e.Token.Line
+	e.Stack[len(i.frames)].File = i.File
+	return e
+}
+
+// stamped records the call stack on a runtime or limit error that was recovered, and passes anything else on.
+func (i *Interpreter) stamped(r any) any {
+	switch e := r.(type) {
+	case errors.RuntimeError:
+		return i.withStack(e)
+	case errors.LimitError:
+		e.RuntimeError = i.withStack(e.RuntimeError)
+		return e
+	}
+	return r
+}
+
+// pushFrame records a call being entered, failing at the call site if that nests calls too deeply. The script
+// itself counts as a call, as it does in the VM.
+func (i *Interpreter) pushFrame(function string) {
+	if depth := i.Limits.Depth(); len(i.frames)+1 >= depth {
+		e := builtins.DepthError(depth)
+		panic(errors.RuntimeError{Token: *i.call, Code: e.Code, Message: e.Message})
+	}
+	i.frames = append(i.frames, callFrame{function: function, call: i.call, file: i.File, globals: i.Globals})
+}
+
+func (i *Interpreter) popFrame() {
+	frame := i.frames[len(i.frames)-1]
+	i.frames = i.frames[:len(i.frames)-1]
+	i.call, i.File, i.Globals = frame.call, frame.file, frame.globals
+}
+
+// checkpoint is where an error can be recovered: how many frames were running and the environment at the time.
+type checkpoint struct {
+	frames      int
+	environment *environment.Environment
+}
+
+func (i *Interpreter) checkpoint() checkpoint {
+	return checkpoint{frames: len(i.frames), environment: i.Environment}
+}
+
+// restore pops the frames and leaves the environments an error unwound through, back to the checkpoint.
+func (i *Interpreter) restore(checkpoint checkpoint) {
+	for len(i.frames) > checkpoint.frames {
+		i.popFrame()
+	}
+	i.Environment = checkpoint.environment
+}
+
+func (i *Interpreter) VisitImportStatement(statement *ast.ImportStatement) any {
+	i.call = &statement.Keyword
+	module := i.native(statement.Path, func() any {
+		return i.modules.Import(i.File, statement.Path.Literal.(string), i.runModule)
+	}).(*builtins.Module)
+
+	if statement.Alias != nil {
+		i.Environment.Define(statement.Alias.Lexeme, module)
+		return nil
+	}
+
+	for _, name := range statement.Names {
+		value := i.native(name, func() any {
+			return module.Export(name.Lexeme)
+		})
+		i.Environment.Define(name.Lexeme, value)
+	}
 	return nil
 }
 
-func (i *Interpreter) VisitFunctionStatement(statement ast.FunctionStatement) any {
-	function := Function{Declaration: statement, Closure: i.Environment}
+// runModule evaluates an imported file in a fresh global scope and returns the globals it defined.
+func (i *Interpreter) runModule(path, source string) map[string]any {
+	statements := modules.Parse(path, source, i.Errors)
+	// Running a module counts as a call, as it is one in the VM. The import's path fills in the token.
+	i.step(ast.Token{})
+
+	checkpoint := i.checkpoint()
+	i.pushFrame("<script>")
+	defer func() {
+		r := i.stamped(recover())
+		i.restore(checkpoint)
+
+		if r != nil {
+			panic(r)
+		}
+	}()
+
+	globals := environment.NewEnvironment(i.natives)
+	i.Globals, i.Environment, i.File = globals, globals, path
+	for _, statement := range statements {
+		i.execute(statement)
+	}
+	return globals.Values
+}
+
+func (i *Interpreter) VisitBlockStatement(statement *ast.BlockStatement) any {
+	if statement.Size == 0 {
+		// Nothing is declared in the block, so it doesn't need an environment of its own
+		return i.executeStatements(statement.Statements)
+	}
+	return i.executeBlock(statement.Statements, environment.NewScope(i.Environment, statement.Size))
+}
+
+func (i *Interpreter) VisitFunctionStatement(statement *ast.FunctionStatement) any {
+	function := Function{Declaration: statement, Closure: i.Environment, Globals: i.Globals, File: i.File}
 	i.Environment.Define(statement.Name.Lexeme, function)
 	return nil
 }
 
-func (i *Interpreter) VisitReturnStatement(statement ast.ReturnStatement) any {
+func (i *Interpreter) VisitReturnStatement(statement *ast.ReturnStatement) any {
 	var value any
 	if statement.Value != nil {
 		value = i.evaluate(statement.Value)
 	}
 
-	panic(Return{Value: value})
+	i.returned = value
+	return Return{}
+}
+
+func (i *Interpreter) VisitClassStatement(statement *ast.ClassStatement) any {
+	var superclass *Class
+	if statement.Superclass != nil {
+		value := i.evaluate(statement.Superclass)
+		class, ok := value.(*Class)
+		if !ok {
+			panic(errors.RuntimeError{Token: statement.Superclass.Name, Code: errors.TYPE_ERROR, Message: "superclass must be a class"})
+		}
+		superclass = class
+	}
+
+	if superclass != nil {
+		i.Environment = environment.NewScope(i.Environment, 1)
+		i.Environment.Define("super", superclass)
+	}
+
+	methods := make(map[string]Function)
+	for _, method := range statement.Methods {
+		methods[method.Name.Lexeme] = Function{Declaration: method, Closure: i.Environment, Globals: i.Globals, File: i.File, IsInitializer: method.Name.Lexeme == "init"}
+	}
+
+	class := &Class{Name: statement.Name.Lexeme, Superclass: superclass, Methods: methods}
+
+	if superclass != nil {
+		i.Environment = i.Environment.Enclosing
+	}
+
+	// Defined only now, but methods can still refer to the class, as they can't run before it exists
+	i.Environment.Define(statement.Name.Lexeme, class)
+	return nil
+}
+
+// Return, Break and Continue are the signals statements finish with to stop the statements around them early, until
+// they get to the call or loop they're aimed at. Every other statement finishes with nil.
+//
+// A Return's value waits in Interpreter.returned for the call to pick up, so returning doesn't allocate.
+type Return struct{}
+
+// Break and Continue are aimed at the loop they target, which is the innermost one when Label is empty.
+type Break struct {
+	Label string
 }
 
-type Return struct {
-	Value any
+type Continue struct {
+	Label string
 }
 
-func (i *Interpreter) VisitLiteralExpression(expression ast.Literal) any {
+func (i *Interpreter) VisitLiteralExpression(expression *ast.Literal) any {
 	return expression.Value
 }
 
-func (i *Interpreter) VisitLogicalExpression(expression ast.Logical) any {
+func (i *Interpreter) VisitLogicalExpression(expression *ast.Logical) any {
 	left := i.evaluate(expression.Left)
 
 	if expression.Operator.Type == ast.OR {
@@ -156,31 +506,205 @@ func (i *Interpreter) VisitLogicalExpression(expression ast.Logical) any {
 	return i.evaluate(expression.Right)
 }
 
-func (i *Interpreter) VisitGroupingExpression(expression ast.Grouping) any {
+func (i *Interpreter) VisitGroupingExpression(expression *ast.Grouping) any {
 	return i.evaluate(expression.Expression)
 }
 
-func (i *Interpreter) VisitCallExpression(expression ast.Call) any {
+func (i *Interpreter) VisitCallExpression(expression *ast.Call) any {
 	callee := i.evaluate(expression.Callee)
 
-	arguments := make([]any, len(expression.Arguments))
+	// A script function's arguments become its scope, so they get room for its other variables too
+	size := len(expression.Arguments)
+	if function, ok := callee.(Function); ok && function.Declaration.Size > size {
+		size = function.Declaration.Size
+	}
+	arguments := make([]any, len(expression.Arguments), size)
 	for index, argument := range expression.Arguments {
 		arguments[index] = i.evaluate(argument)
 	}
 
 	function, ok := callee.(Callable)
 	if !ok {
-		panic(errors.RuntimeError{Token: expression.Paren, Message: "can only call functions and classes"})
+		panic(errors.RuntimeError{Token: expression.Paren, Code: errors.TYPE_ERROR, Message: "can only call functions and classes"})
+	}
+
+	if function.Arity() != builtins.VARIADIC && len(arguments) != function.Arity() {
+		panic(errors.RuntimeError{Token: expression.Paren, Code: errors.WRONG_ARITY, Message: "expected " + fmt.Sprint(function.Arity()) + " arguments but got " + fmt.Sprint(len(arguments))})
+	}
+
+	i.step(expression.Paren)
+	i.call = &expression.Paren
+	if _, ok := function.(*BuiltInFunction); ok {
+		return i.native(expression.Paren, func() any {
+			return function.Call(i, arguments)
+		})
+	}
+
+	return function.Call(i, arguments)
+}
+
+// Call lets natives call back into script code, with the same checks as a call expression.
+func (i *Interpreter) Call(callee any, arguments []any) any {
+	// The native's call expression fills in the token
+	i.step(ast.Token{})
+	function, ok := callee.(Callable)
+	if !ok {
+		panic(builtins.Error{Code: errors.TYPE_ERROR, Message: "can only call functions and classes"})
 	}
 
-	if len(arguments) != function.Arity() {
-		panic(errors.RuntimeError{Token: expression.Paren, Message: "expected " + fmt.Sprint(function.Arity()) + " arguments but got " + fmt.Sprint(len(arguments))})
+	if function.Arity() != builtins.VARIADIC && len(arguments) != function.Arity() {
+		panic(builtins.Error{Code: errors.WRONG_ARITY, Message: "expected " + fmt.Sprint(function.Arity()) + " arguments but got " + fmt.Sprint(len(arguments))})
 	}
 
 	return function.Call(i, arguments)
 }
 
-func (i *Interpreter) VisitUnaryExpression(expression ast.Unary) any {
+func (i *Interpreter) VisitLambdaExpression(expression *ast.Lambda) any {
+	return Function{Declaration: expression.Declaration, Closure: i.Environment, Globals: i.Globals, File: i.File}
+}
+
+func (i *Interpreter) VisitInterpolationExpression(expression *ast.Interpolation) any {
+	var text strings.Builder
+	for _, part := range expression.Parts {
+		text.WriteString(i.stringify(i.evaluate(part)))
+	}
+	i.allocate(expression.Quote, text.Len())
+	return text.String()
+}
+
+func (i *Interpreter) VisitListLiteralExpression(expression *ast.ListLiteral) any {
+	i.allocate(expression.Bracket, builtins.COLLECTION_SIZE+len(expression.Elements)*builtins.VALUE_SIZE)
+	elements := make([]any, len(expression.Elements))
+	for index, element := range expression.Elements {
+		elements[index] = i.evaluate(element)
+	}
+	return builtins.NewList(elements)
+}
+
+func (i *Interpreter) VisitMapLiteralExpression(expression *ast.MapLiteral) any {
+	i.allocate(expression.Brace, builtins.COLLECTION_SIZE+len(expression.Keys)*2*builtins.VALUE_SIZE)
+	m := builtins.NewMap()
+	for index := range expression.Keys {
+		key := i.evaluate(expression.Keys[index])
+		value := i.evaluate(expression.Values[index])
+
+		i.native(expression.Brace, func() any {
+			m.Set(key, value)
+			return nil
+		})
+	}
+	return m
+}
+
+func (i *Interpreter) VisitIndexExpression(expression *ast.Index) any {
+	object := i.evaluate(expression.Object)
+	index := i.evaluate(expression.Index)
+
+	return i.native(expression.Bracket, func() any {
+		return builtins.Index(object, index)
+	})
+}
+
+func (i *Interpreter) VisitIndexSetExpression(expression *ast.IndexSet) any {
+	object := i.evaluate(expression.Object)
+	index := i.evaluate(expression.Index)
+	value := i.evaluate(expression.Value)
+
+	i.native(expression.Bracket, func() any {
+		builtins.SetIndex(i, object, index, value)
+		return nil
+	})
+	return value
+}
+
+func (i *Interpreter) VisitSliceExpression(expression *ast.Slice) any {
+	object := i.evaluate(expression.Object)
+
+	var start, end any
+	if expression.Start != nil {
+		start = i.evaluate(expression.Start)
+	}
+	if expression.End != nil {
+		end = i.evaluate(expression.End)
+	}
+
+	return i.native(expression.Bracket, func() any {
+		return builtins.Slice(i, object, start, end)
+	})
+}
+
+// native runs logic from the builtins package, turning any error it raises into a runtime error at the given token.
+func (i *Interpreter) native(token ast.Token, logic func() any) (value any) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(builtins.Error); ok {
+				panic(errors.RuntimeError{Token: token, Code: e.Code, Message: e.Message})
+			}
+			if e, ok := r.(errors.LimitError); ok && e.Token.Line == 0 {
+				e.Token = token
+				panic(e)
+			}
+			panic(r)
+		}
+	}()
+
+	return logic()
+}
+
+func (i *Interpreter) VisitGetExpression(expression *ast.Get) any {
+	object := i.evaluate(expression.Object)
+	if instance, ok := object.(*Instance); ok {
+		return instance.Get(expression.Name)
+	}
+	if module, ok := object.(*builtins.Module); ok {
+		return i.native(expression.Name, func() any {
+			return module.Export(expression.Name.Lexeme)
+		})
+	}
+	if exception, ok := object.(*builtins.Exception); ok {
+		return i.native(expression.Name, func() any {
+			return exception.Field(expression.Name.Lexeme)
+		})
+	}
+
+	panic(errors.RuntimeError{Token: expression.Name, Code: errors.TYPE_ERROR, Message: "only instances have properties"})
+}
+
+func (i *Interpreter) VisitSetExpression(expression *ast.Set) any {
+	object := i.evaluate(expression.Object)
+	instance, ok := object.(*Instance)
+	if !ok {
+		panic(errors.RuntimeError{Token: expression.Name, Code: errors.TYPE_ERROR, Message: "only instances have fields"})
+	}
+
+	value := i.evaluate(expression.Value)
+	// A new field costs as much as a new map entry
+	if _, ok := instance.Fields[expression.Name.Lexeme]; !ok {
+		i.allocate(expression.Name, 2*builtins.VALUE_SIZE)
+	}
+	instance.Set(expression.Name, value)
+	return value
+}
+
+func (i *Interpreter) VisitThisExpression(expression *ast.This) any {
+	return i.lookUpVariable(expression.Keyword, expression.Binding)
+}
+
+func (i *Interpreter) VisitSuperExpression(expression *ast.Super) any {
+	binding := expression.Binding
+	superclass := i.Environment.GetAt(binding.Depth, binding.Slot).(*Class)
+	// 'this' always lives alone in the scope right inside the one holding 'super'
+	instance := i.Environment.GetAt(binding.Depth-1, 0).(*Instance)
+
+	method, ok := superclass.FindMethod(expression.Method.Lexeme)
+	if !ok {
+		panic(errors.RuntimeError{Token: expression.Method, Code: errors.UNDEFINED_PROPERTY, Message: "undefined property '" + expression.Method.Lexeme + "'"})
+	}
+
+	return method.Bind(instance)
+}
+
+func (i *Interpreter) VisitUnaryExpression(expression *ast.Unary) any {
 	right := i.evaluate(expression.Right)
 
 	switch expression.Operator.Type {
@@ -200,11 +724,18 @@ func (i *Interpreter) VisitUnaryExpression(expression ast.Unary) any {
 	return nil
 }
 
-func (i *Interpreter) VisitVariableExpression(expression ast.Variable) any {
-	return i.Environment.Get(expression.Name)
+func (i *Interpreter) VisitVariableExpression(expression *ast.Variable) any {
+	return i.lookUpVariable(expression.Name, expression.Binding)
 }
 
-func (i *Interpreter) VisitBinaryExpression(expression ast.Binary) any {
+func (i *Interpreter) lookUpVariable(name ast.Token, binding ast.Binding) any {
+	if binding.Local {
+		return i.Environment.GetAt(binding.Depth, binding.Slot)
+	}
+	return i.Globals.Get(name)
+}
+
+func (i *Interpreter) VisitBinaryExpression(expression *ast.Binary) any {
 	left := i.evaluate(expression.Left)
 	right := i.evaluate(expression.Right)
 
@@ -232,10 +763,11 @@ func (i *Interpreter) VisitBinaryExpression(expression ast.Binary) any {
 		}
 		if lf, lok := left.(string); lok {
 			if rf, rok := right.(string); rok {
+				i.allocate(expression.Operator, len(lf)+len(rf))
 				return lf + rf
 			}
 		}
-		panic(errors.RuntimeError{Token: expression.Operator, Message: "operands must be either two numbers or two strings"})
+		panic(errors.RuntimeError{Token: expression.Operator, Code: errors.TYPE_ERROR, Message: "operands must be either two numbers or two strings"})
 	case ast.GREATER:
 		i.checkNumberOperands(expression.Operator, left, right)
 		return left.(float64) > right.(float64)
@@ -257,9 +789,13 @@ func (i *Interpreter) VisitBinaryExpression(expression ast.Binary) any {
 	return nil
 }
 
-func (i *Interpreter) VisitAssignExpression(expression ast.Assign) any {
+func (i *Interpreter) VisitAssignExpression(expression *ast.Assign) any {
 	value := i.evaluate(expression.Value)
-	i.Environment.Assign(expression.Name, value)
+	if binding := expression.Binding; binding.Local {
+		i.Environment.AssignAt(binding.Depth, binding.Slot, value)
+	} else {
+		i.Globals.Assign(expression.Name, value)
+	}
 	return value
 }
 
@@ -267,43 +803,37 @@ func (i *Interpreter) evaluate(expression ast.Expression) any {
 	return expression.Accept(i)
 }
 
+// execute runs a statement and returns the signal it finished with, if it's stopping early.
 func (i *Interpreter) execute(statement ast.Statement) any {
 	return statement.Accept(i)
 }
 
-func (i *Interpreter) executeBlock(statements []ast.Statement, environment *environment.Environment) {
+func (i *Interpreter) executeBlock(statements []ast.Statement, environment *environment.Environment) any {
+	// An error leaves the environment as it is, for whatever recovers it to restore
 	previous := i.Environment
-	defer func() {
-		i.Environment = previous
-	}()
-
 	i.Environment = environment
+	signal := i.executeStatements(statements)
+	i.Environment = previous
+	return signal
+}
+
+// executeStatements runs statements in the current environment, stopping at the first one that signals a return,
+// break or continue.
+func (i *Interpreter) executeStatements(statements []ast.Statement) any {
 	for _, statement := range statements {
-		i.execute(statement)
+		if signal := i.execute(statement); signal != nil {
+			return signal
+		}
 	}
+	return nil
 }
 
 func (i *Interpreter) isTruthy(object any) bool {
-	if object == nil {
-		return false
-	}
-	if boolean, ok := object.(bool); ok {
-		return boolean
-	}
-
-	return true
+	return builtins.IsTruthy(object)
 }
 
 func (i *Interpreter) isEqual(a, b any) bool {
-	if a == nil && b == nil {
-		return true
-	}
-
-	if a == nil || b == nil {
-		return false
-	}
-
-	return a == b
+	return builtins.Equal(a, b)
 }
 
 func (i *Interpreter) checkNumberOperand(operator ast.Token, operand any) {
@@ -311,7 +841,7 @@ func (i *Interpreter) checkNumberOperand(operator ast.Token, operand any) {
 		return
 	}
 
-	panic(errors.RuntimeError{Token: operator, Message: "operand must be a number"})
+	panic(errors.RuntimeError{Token: operator, Code: errors.TYPE_ERROR, Message: "operand must be a number"})
 }
 
 func (i *Interpreter) checkNumberOperands(operator ast.Token, left, right any) {
@@ -321,21 +851,9 @@ func (i *Interpreter) checkNumberOperands(operator ast.Token, left, right any) {
 		}
 	}
 
-	panic(errors.RuntimeError{Token: operator, Message: "operands must be numbers"})
+	panic(errors.RuntimeError{Token: operator, Code: errors.TYPE_ERROR, Message: "operands must be numbers"})
 }
 
 func (i *Interpreter) stringify(object any) string {
-	if object == nil {
-		return "nil"
-	}
-
-	if number, ok := object.(float64); ok {
-		text := fmt.Sprint(number)
-		if strings.HasSuffix(text, ".0") {
-			text = text[0 : len(text)-2]
-		}
-		return text
-	}
-
-	return fmt.Sprint(object)
+	return builtins.Stringify(object)
 }
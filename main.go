@@ -2,99 +2,242 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"flag"
 	"fmt"
+	"jota/builtins"
+	"jota/embed"
 	"jota/errors"
-	"jota/interpreter"
-	"jota/parser"
-	"jota/scanner"
+	"jota/modules"
 	"jota/utils"
-	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
-// Globals
+// Exit statuses, following the BSD sysexits.h conventions
+const (
+	EXIT_USAGE   = 64
+	EXIT_SYNTAX  = 65
+	EXIT_RUNTIME = 70
+	EXIT_IO      = 74
+)
+
+// Globals, set up once the flags have been parsed
 var (
-	errHandler = errors.ErrorHandler{
-		Error:        false,
-		RuntimeError: false,
-		Log:          *log.New(os.Stderr, "", 0),
-	}
-	globalInterpreter = interpreter.NewInterpreter(errHandler)
+	// Every phase reports to this one sink, so the exit status can reflect whatever went wrong
+	errHandler    errors.Sink
+	globalRuntime *embed.Runtime
+	// How to color what's written to stdout and stderr, which may not both be terminals
+	stdout, stderr utils.Style
+
+	engine = flag.String("engine", "tree", "the execution engine to use: 'tree' (tree-walking interpreter) or 'vm' (bytecode VM)")
+	format = flag.String("diagnostics", "text", "how errors are reported on stderr: 'text' (for people) or 'json' (one object per line, for tools)")
+	color  = flag.String("color", "auto", "when to use colors: 'auto' (only in a terminal, unless NO_COLOR is set), 'always' or 'never'")
+	theme  = flag.String("theme", "dark", "the colors to use: 'dark' or 'light', to suit the terminal's background")
+
+	maxSteps  = flag.Int("max-steps", 0, "stop a script after this many loop iterations and calls (0 means no limit)")
+	timeout   = flag.Duration("timeout", 0, "stop a script that runs for longer than this, e.g. '5s' (0 means no limit)")
+	maxMemory = flag.Int("max-memory", 0, "stop a script after it allocates this many megabytes of strings, lists, maps and instances (0 means no limit)")
+	maxDepth  = flag.Int("max-depth", builtins.DEFAULT_MAX_DEPTH, "how deeply calls may nest before it's a runtime error (at most "+strconv.Itoa(builtins.MAX_DEPTH)+")")
+
+	// Scripts can do anything, unless they're sandboxed and only get what the --allow flags grant
+	sandbox           = flag.Bool("sandbox", false, "only let the script do what the --allow flags grant (which any of them turns on too)")
+	allowRead         directories
+	allowWrite        directories
+	allowExec         = flag.Bool("allow-exec", false, "reserved for letting a sandboxed script run programs (no native does yet)")
+	allowEnv          = flag.Bool("allow-env", false, "reserved for letting a sandboxed script read environment variables (no native does yet)")
+	allowNetLocalhost = flag.Bool("allow-net-localhost", false, "reserved for letting a sandboxed script connect to localhost (no native does yet)")
+	allowClock        = flag.Bool("allow-clock", false, "let a sandboxed script read the clock")
 )
 
+// directories is a flag that can be given several times, each with one or more comma-separated directories.
+type directories []string
+
+func (d *directories) String() string {
+	return strings.Join(*d, ",")
+}
+
+func (d *directories) Set(value string) error {
+	*d = append(*d, strings.Split(value, ",")...)
+	return nil
+}
+
 func main() {
-	args := os.Args[1:]
+	flag.Usage = func() {
+		fmt.Println(stdout.Paint(utils.USAGE, "Usage ->") + " " + stdout.Paint(utils.TEXT, "jota [--engine=tree|vm] [--diagnostics=text|json] [--color=auto|always|never] [--theme=dark|light] [--max-steps=N] [--timeout=D] [--max-memory=MB] [--max-depth=N] [--sandbox] [--allow-read=DIR] [--allow-write=DIR] [--allow-exec] [--allow-env] [--allow-net-localhost] [--allow-clock] [file.jota] | jota check file.jota"))
+		fmt.Println(stdout.Paint(utils.TEXT, "--allow-write, --allow-exec, --allow-env and --allow-net-localhost are reserved: they turn the sandbox on, but no native uses them yet"))
+	}
+	flag.Var(&allowRead, "allow-read", "let a sandboxed script read files in these directories (e.g. to import them)")
+	flag.Var(&allowWrite, "allow-write", "reserved for letting a sandboxed script write files in these directories (no native does yet)")
+	flag.Parse()
+
+	palette := utils.DarkTheme
+	if *theme == "light" {
+		palette = utils.LightTheme
+	}
+	stdout = utils.NewStyle(os.Stdout, *color, palette)
+	stderr = utils.NewStyle(os.Stderr, *color, palette)
+
+	if *color != "auto" && *color != "always" && *color != "never" {
+		usageError("The color mode must be 'auto', 'always' or 'never'")
+	}
+	if *theme != "dark" && *theme != "light" {
+		usageError("The theme must be either 'dark' or 'light'")
+	}
+	if *engine != "tree" && *engine != "vm" {
+		usageError("The engine must be either 'tree' or 'vm'")
+	}
+	if *maxSteps < 0 || *timeout < 0 || *maxMemory < 0 {
+		usageError("Limits can't be negative")
+	}
+	if *maxDepth < 1 || *maxDepth > builtins.MAX_DEPTH {
+		usageError("The maximum depth must be between 1 and " + strconv.Itoa(builtins.MAX_DEPTH))
+	}
+
+	switch *format {
+	case "text":
+		errHandler = errors.NewErrorHandler(os.Stderr, stderr)
+	case "json":
+		errHandler = errors.NewJSONHandler(os.Stderr)
+	default:
+		usageError("The diagnostics format must be either 'text' or 'json'")
+	}
+	// The runtime collects the errors to return them, and passes them on to be printed
+	limits := builtins.Limits{MaxSteps: *maxSteps, Timeout: *timeout, MaxMemory: *maxMemory << 20, MaxDepth: *maxDepth}
+	runtime, err := embed.New(embed.Options{Engine: *engine, Errors: errHandler, Limits: limits, Capabilities: capabilities()})
+	if err != nil {
+		usageError(err.Error())
+	}
+	globalRuntime = runtime
+
+	args := flag.Args()
+
+	// 'jota check file.jota' looks for errors without running anything
+	command := runFile
+	if len(args) > 0 && args[0] == "check" {
+		command = checkFile
+		args = args[1:]
+		if len(args) != 1 {
+			usageError("'check' takes exactly one .jota file")
+		}
+	}
 	length := len(args)
 
 	if length > 1 {
-		fmt.Println(utils.Yellow + "Usage -> " + utils.White + "jota [file.jota]" + utils.Reset)
-		os.Exit(0)
+		usageError("Only one file can be run at a time")
 	} else if length == 1 {
 		if filepath.Ext(args[0]) != ".jota" {
-			fmt.Println(utils.Yellow + "Usage ->" + utils.White + " You must enter an existing .jota file" + utils.Reset)
-			fmt.Println(utils.Yellow + "Usage ->" + utils.White + " jota [file.jota]" + utils.Reset)
-			os.Exit(0)
+			usageError("You must enter an existing .jota file")
 		}
-		err := runFile(args[0])
+		err := command(args[0])
 		// If the error is not nil, it means that the error is not about non-existing files, so we should send a different error message to the user
 		if err != nil {
-			fmt.Println(utils.Red+"Error ->"+utils.White+" There was an error not related to a non-existing file:\n", err, "\n\n"+utils.Magenta+"Suggestion -> "+utils.White+"If you believe that this is an issue with the interpreter, please send an issue at "+utils.Blue+"https://github.com/mattishere/jota/issues"+utils.Reset)
+			fmt.Println(stdout.Paint(utils.ERROR, "Error ->"), stdout.Paint(utils.TEXT, "There was an error not related to a non-existing file:\n"), err)
+			fmt.Println("\n" + stdout.Paint(utils.SUGGESTION, "Suggestion ->") + " " + stdout.Paint(utils.TEXT, "If you believe that this is an issue with the interpreter, please send an issue at") + " " + stdout.Paint(utils.LINK, "https://github.com/mattishere/jota/issues"))
+			os.Exit(EXIT_IO)
 		}
 	} else {
 		runREPL()
 	}
 }
 
-func runFile(path string) error {
+// capabilities is what the flags let scripts do: anything, unless they asked for a sandbox.
+func capabilities() builtins.Capabilities {
+	sandboxed := *sandbox
+	flag.Visit(func(f *flag.Flag) {
+		if strings.HasPrefix(f.Name, "allow-") {
+			sandboxed = true
+		}
+	})
+	if !sandboxed {
+		return builtins.AllCapabilities()
+	}
+
+	return builtins.Capabilities{
+		Read:         allowRead,
+		Write:        allowWrite,
+		Exec:         *allowExec,
+		Env:          *allowEnv,
+		NetLocalhost: *allowNetLocalhost,
+		Clock:        *allowClock,
+	}
+}
+
+// usageError explains what was wrong with how jota was run, then shows the usage and exits.
+func usageError(message string) {
+	fmt.Println(stdout.Paint(utils.USAGE, "Usage ->") + " " + stdout.Paint(utils.TEXT, message))
+	flag.Usage()
+	os.Exit(EXIT_USAGE)
+}
+
+// readSource reads a script, exiting with the usage if it doesn't exist.
+func readSource(path string) (string, error) {
 	bytes, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			fmt.Println(utils.Yellow + "Usage ->" + utils.White + " You must enter an existing .jota file" + utils.Reset)
-			fmt.Println(utils.Yellow + "Usage ->" + utils.White + " jota [file.jota]" + utils.Reset)
-			return nil
+			usageError("You must enter an existing .jota file")
 		}
-		return err
+		return "", err
 	}
+	return string(bytes), nil
+}
 
-	run(string(bytes))
+func runFile(path string) error {
+	err := globalRuntime.RunFile(context.Background(), path)
+	switch err.(type) {
+	case nil:
+		return nil
+	case *embed.SyntaxError:
+		os.Exit(EXIT_SYNTAX)
+	case embed.RuntimeError, embed.LimitError:
+		os.Exit(EXIT_RUNTIME)
+	}
 
-	// Since this is reading from a file, we need to stop execution if we encounter an error (in the REPL, we don't need to do this)
-	if errHandler.Error || errHandler.RuntimeError {
-		os.Exit(0)
+	if os.IsNotExist(err) {
+		usageError("You must enter an existing .jota file")
 	}
+	return err
+}
 
+// checkFile reports every error in a file at once, without running it.
+func checkFile(path string) error {
+	source, err := readSource(path)
+	if err != nil {
+		return err
+	}
+
+	_, diagnostics := modules.Check(path, source)
+	if len(diagnostics) == 0 {
+		fmt.Println(stdout.Paint(utils.SUCCESS, "No errors found in "+path))
+		return nil
+	}
+
+	errors.Report(diagnostics, errHandler)
+	summary := "Found " + strconv.Itoa(len(diagnostics)) + " errors in " + path
+	if len(diagnostics) == 1 {
+		summary = "Found 1 error in " + path
+	}
+	// Anything but the errors themselves would trip up tools reading them as JSON
+	if *format == "text" {
+		fmt.Fprintln(os.Stderr, stderr.Paint(utils.ERROR, summary))
+	}
+	os.Exit(EXIT_SYNTAX)
 	return nil
 }
 
 func runREPL() {
 	scanner := bufio.NewScanner(os.Stdin)
 	for {
-		fmt.Print(utils.Green + "->" + utils.Reset + " ")
+		fmt.Print(stdout.Paint(utils.SUCCESS, "->") + " ")
 		if !scanner.Scan() {
 			break
 		}
 		line := scanner.Text()
 
-		run(line)
-		// We reset the errors
-		errHandler.Error = false
+		// The error has already been printed, and a bad line shouldn't count against the ones after it
+		globalRuntime.Eval(context.Background(), line)
+		errHandler.Reset()
 	}
 }
-
-func run(source string) {
-	scanner := scanner.CreateScanner(source, errHandler)
-	tokens := scanner.ScanTokens()
-	parser := parser.NewParser(tokens, errHandler)
-	statements := parser.Parse()
-
-	if statements == nil {
-		return
-	}
-
-	if errHandler.Error {
-		return
-	}
-
-	globalInterpreter.Interpret(statements)
-}
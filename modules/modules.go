@@ -0,0 +1,151 @@
+package modules
+
+import (
+	"jota/ast"
+	"jota/builtins"
+	"jota/errors"
+	"jota/parser"
+	"jota/resolver"
+	"jota/scanner"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Loader finds the files behind import statements and makes sure each one is only evaluated once per engine. The
+// evaluation itself is left to the engine, through the function handed to Import.
+type Loader struct {
+	// SearchPath holds the directories tried after the importing file's own one, taken from JOTA_PATH
+	SearchPath []string
+	// Capabilities decide which files may be imported
+	Capabilities builtins.Capabilities
+
+	modules map[string]*builtins.Module
+	// The files currently being evaluated, outermost first, used to catch import cycles
+	loading []string
+}
+
+func NewLoader(capabilities builtins.Capabilities) *Loader {
+	var searchPath []string
+	for _, dir := range filepath.SplitList(os.Getenv("JOTA_PATH")) {
+		if dir != "" {
+			searchPath = append(searchPath, dir)
+		}
+	}
+
+	return &Loader{SearchPath: searchPath, Capabilities: capabilities, modules: make(map[string]*builtins.Module)}
+}
+
+// Import returns the module that path points to, relative to the importing file (or to the working directory, when
+// importer is empty as it is in the REPL). The first import of a file evaluates it with run, which returns the
+// globals it defined; later ones get the cached module. Problems are raised as builtins.Error panics.
+func (l *Loader) Import(importer, path string, run func(path, source string) map[string]any) *builtins.Module {
+	resolved := l.resolve(importer, path)
+	if module, ok := l.modules[resolved]; ok {
+		return module
+	}
+
+	// The file that started everything isn't a module, but importing it back still closes a cycle
+	loading := l.loading
+	if len(loading) == 0 && importer != "" {
+		if absolute, err := filepath.Abs(importer); err == nil {
+			loading = []string{absolute}
+		}
+	}
+
+	for index, file := range loading {
+		if file == resolved {
+			var cycle []string
+			for _, file := range append(loading[index:], resolved) {
+				cycle = append(cycle, filepath.Base(file))
+			}
+			panic(builtins.Error{Code: errors.IMPORT_FAILED, Message: "import cycle: " + strings.Join(cycle, " -> ")})
+		}
+	}
+
+	source, err := os.ReadFile(resolved)
+	if err != nil {
+		panic(builtins.Error{Code: errors.IMPORT_FAILED, Message: "can't read module '" + path + "': " + err.Error()})
+	}
+
+	previous := l.loading
+	l.loading = append(loading, resolved)
+	defer func() {
+		l.loading = previous
+	}()
+
+	name := strings.TrimSuffix(filepath.Base(resolved), filepath.Ext(resolved))
+	module := &builtins.Module{Name: name, Path: resolved, Exports: run(resolved, string(source))}
+	l.modules[resolved] = module
+	return module
+}
+
+// resolve turns an import path into the absolute path of an existing file the script may read. The '.jota'
+// extension can be left out.
+func (l *Loader) resolve(importer, path string) string {
+	if filepath.Ext(path) == "" {
+		path += ".jota"
+	}
+
+	var candidates []string
+	if filepath.IsAbs(path) {
+		candidates = append(candidates, path)
+	} else {
+		dir := "."
+		if importer != "" {
+			dir = filepath.Dir(importer)
+		}
+		candidates = append(candidates, filepath.Join(dir, path))
+		for _, dir := range l.SearchPath {
+			candidates = append(candidates, filepath.Join(dir, path))
+		}
+	}
+
+	// Files the script may not read aren't even looked at, so a sandboxed script can't use imports to find out what
+	// exists outside its directories: a module that's missing and one it may not read fail the same way
+	denied := false
+	for _, candidate := range candidates {
+		if !l.Capabilities.CanRead(candidate) {
+			denied = true
+			continue
+		}
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			if absolute, err := filepath.Abs(candidate); err == nil {
+				return absolute
+			}
+			return candidate
+		}
+	}
+
+	if denied {
+		panic(builtins.Error{Code: errors.PERMISSION_DENIED, Message: "can't import '" + path + "': it doesn't exist, or reading it needs the '" + string(builtins.FS_READ) + "' capability for its directory"})
+	}
+	panic(builtins.Error{Code: errors.IMPORT_FAILED, Message: "can't find module '" + path + "' next to the importing file or in JOTA_PATH"})
+}
+
+// Check runs the scanner, parser and resolver over source, which was read from path (empty for the REPL), and
+// returns every problem they found. The statements are only fit to run if there are none.
+func Check(path, source string) ([]ast.Statement, []errors.Diagnostic) {
+	tokens, diagnostics := scanner.CreateScanner(source, path).ScanTokens()
+	statements, parseDiagnostics := parser.NewParser(tokens).Parse()
+	resolveDiagnostics := resolver.NewResolver().Resolve(statements)
+
+	diagnostics = append(diagnostics, parseDiagnostics...)
+	diagnostics = append(diagnostics, resolveDiagnostics...)
+	// Each phase finds its problems in order, so sorting them puts them in the order they appear in the file
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		return diagnostics[i].Span.Offset < diagnostics[j].Span.Offset
+	})
+	return statements, diagnostics
+}
+
+// Parse runs the front end over a module's source, reporting any problems before it panics.
+func Parse(path, source string, sink errors.Sink) []ast.Statement {
+	statements, diagnostics := Check(path, source)
+	if len(diagnostics) > 0 {
+		errors.Report(diagnostics, sink)
+		panic(builtins.Error{Code: errors.IMPORT_FAILED, Message: "module '" + filepath.Base(path) + "' has syntax errors"})
+	}
+	return statements
+}
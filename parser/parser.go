@@ -6,22 +6,24 @@ import (
 )
 
 type Parser struct {
-	Tokens       []ast.Token
-	current      int
-	ErrorHandler errors.ErrorHandler
+	Tokens      []ast.Token
+	current     int
+	diagnostics []errors.Diagnostic
 }
 
-func NewParser(tokens []ast.Token, errorHandler errors.ErrorHandler) *Parser {
-	return &Parser{Tokens: tokens, current: 0, ErrorHandler: errorHandler}
+func NewParser(tokens []ast.Token) *Parser {
+	return &Parser{Tokens: tokens, current: 0}
 }
 
-func (p *Parser) Parse() []ast.Statement {
+// Parse returns the statements in the tokens along with every syntax error found. After an error the parser skips
+// ahead to the next statement and carries on, leaving a nil statement behind for the one it gave up on.
+func (p *Parser) Parse() ([]ast.Statement, []errors.Diagnostic) {
 	var statements []ast.Statement
 	for !p.isAtEnd() {
 		statement := p.declaration()
 		statements = append(statements, statement)
 	}
-	return statements
+	return statements, p.diagnostics
 }
 
 func (p *Parser) expression() ast.Expression {
@@ -37,26 +39,168 @@ func (p *Parser) declaration() ast.Statement {
 		}
 	}()
 
-	if p.match(ast.FUNCTION) {
+	if p.match(ast.CLASS) {
+		return p.classDeclaration()
+	}
+
+	// Without a name, 'function' starts an anonymous function expression instead
+	if p.check(ast.FUNCTION) && p.checkAhead(1, ast.IDENTIFIER) {
+		p.advance()
 		return p.function("function")
 	}
 
 	if p.match(ast.VARIABLE) {
 		return p.variableDeclaration()
 	}
+
+	if p.match(ast.IMPORT, ast.FROM) {
+		return p.importDeclaration()
+	}
+
+	// A common slip for people coming from other languages, which would otherwise fail with a confusing ';' error
+	if keyword := p.peek(); p.check(ast.IDENTIFIER) && (keyword.Lexeme == "var" || keyword.Lexeme == "let") &&
+		p.checkAhead(1, ast.IDENTIFIER) {
+		p.error(errors.UNKNOWN_KEYWORD, keyword, keyword.Span(), "'"+keyword.Lexeme+"' is not a keyword",
+			"variables are declared with 'assign', as in 'assign "+p.Tokens[p.current+1].Lexeme+" = ...;'")
+		panic(ParseError{})
+	}
 	return p.statement()
 }
 
+func (p *Parser) tryStatement() ast.Statement {
+	statement := &ast.TryStatement{Keyword: p.previous()}
+
+	p.consume(ast.LEFT_BRACE, "expected '{' after 'try'")
+	statement.Body = &ast.BlockStatement{Statements: p.block()}
+
+	if p.match(ast.CATCH) {
+		if p.match(ast.LEFT_BRACKET) {
+			name := p.consume(ast.IDENTIFIER, "expected a name for the caught error")
+			statement.CatchName = &name
+			p.consume(ast.RIGHT_BRACKET, "expected ')' after the caught error's name")
+		}
+		p.consume(ast.LEFT_BRACE, "expected '{' after 'catch'")
+		statement.Catch = &ast.BlockStatement{Statements: p.block()}
+	}
+
+	if p.match(ast.FINALLY) {
+		p.consume(ast.LEFT_BRACE, "expected '{' after 'finally'")
+		statement.Finally = &ast.BlockStatement{Statements: p.block()}
+	}
+
+	if statement.Catch == nil && statement.Finally == nil {
+		panic(p.throwError(errors.INCOMPLETE_TRY, p.peek(), "expected 'catch' or 'finally' after a try block"))
+	}
+	return statement
+}
+
+func (p *Parser) importDeclaration() ast.Statement {
+	keyword := p.previous()
+	path := p.consume(ast.STRING, "expected a module path string after '"+keyword.Lexeme+"'")
+
+	if keyword.Type == ast.IMPORT {
+		p.consume(ast.AS, "expected 'as' after the module path")
+		alias := p.consume(ast.IDENTIFIER, "expected a name for the module after 'as'")
+		p.consume(ast.SEMICOLON, "expected ';' after an import")
+		return &ast.ImportStatement{Keyword: keyword, Path: path, Alias: &alias}
+	}
+
+	p.consume(ast.IMPORT, "expected 'import' after the module path")
+	var names []ast.Token
+	for {
+		names = append(names, p.consume(ast.IDENTIFIER, "expected a name to import"))
+		if !p.match(ast.COMMA) {
+			break
+		}
+	}
+	p.consume(ast.SEMICOLON, "expected ';' after an import")
+	return &ast.ImportStatement{Keyword: keyword, Path: path, Names: names}
+}
+
+func (p *Parser) classDeclaration() ast.Statement {
+	name := p.consume(ast.IDENTIFIER, "expected a class name")
+
+	var superclass *ast.Variable
+	if p.match(ast.LESS) {
+		p.consume(ast.IDENTIFIER, "expected a superclass name")
+		superclass = &ast.Variable{Name: p.previous()}
+	}
+
+	p.consume(ast.LEFT_BRACE, "expected '{' before class body")
+
+	var methods []*ast.FunctionStatement
+	for !p.check(ast.RIGHT_BRACE) && !p.isAtEnd() {
+		methods = append(methods, p.function("method"))
+	}
+
+	p.consume(ast.RIGHT_BRACE, "expected '}' after class body")
+	return &ast.ClassStatement{Name: name, Superclass: superclass, Methods: methods}
+}
+
 func (p *Parser) function(kind string) *ast.FunctionStatement {
 	name := p.consume(ast.IDENTIFIER, "a "+kind+" is expected")
 	p.consume(ast.LEFT_BRACKET, "expected '(' after "+kind+" name")
+	parameters := p.parameters()
+
+	p.consume(ast.LEFT_BRACE, "expected '{' before "+kind+" body")
+	body := p.block()
+	return &ast.FunctionStatement{Name: name, Params: parameters, Body: body}
+}
+
+// lambda parses an anonymous function, whose 'function' keyword (or opening bracket, for arrow functions) has
+// already been consumed.
+func (p *Parser) lambda(arrow bool) ast.Expression {
+	keyword := p.previous()
+	name := ast.Token{Type: ast.IDENTIFIER, Lexeme: "anonymous", Line: keyword.Line}
+	if !arrow {
+		p.consume(ast.LEFT_BRACKET, "expected '(' after 'function'")
+	}
+	parameters := p.parameters()
+
+	var body []ast.Statement
+	if arrow {
+		arrowToken := p.consume(ast.ARROW, "expected '=>' after arrow function parameters")
+		if p.match(ast.LEFT_BRACE) {
+			body = p.block()
+		} else {
+			// An expression body is returned as-is
+			value := p.expression()
+			body = []ast.Statement{&ast.ReturnStatement{Keyword: arrowToken, Value: value}}
+		}
+	} else {
+		p.consume(ast.LEFT_BRACE, "expected '{' before function body")
+		body = p.block()
+	}
+
+	return &ast.Lambda{Declaration: &ast.FunctionStatement{Name: name, Params: parameters, Body: body}}
+}
 
+// isArrowFunction looks ahead from a '(' to see if it opens arrow function parameters rather than a grouping.
+func (p *Parser) isArrowFunction() bool {
+	offset := 1
+	if !p.checkAhead(offset, ast.RIGHT_BRACKET) {
+		for {
+			if !p.checkAhead(offset, ast.IDENTIFIER) {
+				return false
+			}
+			offset++
+			if !p.checkAhead(offset, ast.COMMA) {
+				break
+			}
+			offset++
+		}
+	}
+	return p.checkAhead(offset, ast.RIGHT_BRACKET) && p.checkAhead(offset+1, ast.ARROW)
+}
+
+// parameters parses a parameter list up to and including the closing ')'.
+func (p *Parser) parameters() []ast.Token {
 	var parameters []ast.Token
 
 	if !p.check(ast.RIGHT_BRACKET) {
 		for {
 			if len(parameters) >= 255 {
-				errors.Err(p.peek(), "you are not allowed to have more than 255 parameters", p.ErrorHandler)
+				p.error(errors.TOO_MANY_ARGUMENTS, p.peek(), p.peek().Span(), "you are not allowed to have more than 255 parameters", "")
 			}
 
 			param := p.consume(ast.IDENTIFIER, "expected a parameter name")
@@ -69,16 +213,13 @@ func (p *Parser) function(kind string) *ast.FunctionStatement {
 
 		for p.match(ast.COMMA) {
 			if len(parameters) > 255 {
-				errors.Err(p.peek(), "you are not allowed to have more than 255 parameters", p.ErrorHandler)
+				p.error(errors.TOO_MANY_ARGUMENTS, p.peek(), p.peek().Span(), "you are not allowed to have more than 255 parameters", "")
 			}
 			parameters = append(parameters, p.consume(ast.IDENTIFIER, "expected a parameter name"))
 		}
 	}
 	p.consume(ast.RIGHT_BRACKET, "expected ')' after parameters")
-
-	p.consume(ast.LEFT_BRACE, "expected '{' before "+kind+" body")
-	body := p.block()
-	return &ast.FunctionStatement{Name: name, Params: parameters, Body: body}
+	return parameters
 }
 
 func (p *Parser) statement() ast.Statement {
@@ -86,18 +227,47 @@ func (p *Parser) statement() ast.Statement {
 		return p.ifStatement()
 	}
 
+	if p.check(ast.IDENTIFIER) && p.checkAhead(1, ast.COLON) {
+		return p.labelledStatement()
+	}
+
 	if p.match(ast.WHILE) {
-		return p.whileStatement()
+		return p.whileStatement(nil)
 	}
 
 	if p.match(ast.FOR) {
-		return p.forStatement()
+		return p.forStatement(nil)
+	}
+
+	if p.match(ast.BREAK) {
+		keyword := p.previous()
+		label := p.loopLabel()
+		p.consume(ast.SEMICOLON, "expected ';' after 'break'")
+		return &ast.BreakStatement{Keyword: keyword, Label: label}
+	}
+
+	if p.match(ast.CONTINUE) {
+		keyword := p.previous()
+		label := p.loopLabel()
+		p.consume(ast.SEMICOLON, "expected ';' after 'continue'")
+		return &ast.ContinueStatement{Keyword: keyword, Label: label}
 	}
 
 	if p.match(ast.PRINT) {
 		return p.printStatement()
 	}
 
+	if p.match(ast.THROW) {
+		keyword := p.previous()
+		value := p.expression()
+		p.consume(ast.SEMICOLON, "expected ';' after the thrown value")
+		return &ast.ThrowStatement{Keyword: keyword, Value: value}
+	}
+
+	if p.match(ast.TRY) {
+		return p.tryStatement()
+	}
+
 	if p.match(ast.RETURN) {
 		return p.returnStatement()
 	}
@@ -146,17 +316,46 @@ func (p *Parser) ifStatement() ast.Statement {
 	return &ast.IfStatement{Condition: condition, ThenBranch: thenBranch, ElseBranch: elseBranch}
 }
 
-func (p *Parser) whileStatement() ast.Statement {
+func (p *Parser) labelledStatement() ast.Statement {
+	label := p.advance()
+	p.advance() // The ':'
+
+	if p.match(ast.WHILE) {
+		return p.whileStatement(&label)
+	}
+	if p.match(ast.FOR) {
+		return p.forStatement(&label)
+	}
+
+	panic(p.throwError(errors.INVALID_LABEL, label, "only loops can be labelled"))
+}
+
+// loopLabel parses the optional label after 'break' or 'continue'.
+func (p *Parser) loopLabel() *ast.Token {
+	if p.match(ast.IDENTIFIER) {
+		label := p.previous()
+		return &label
+	}
+	return nil
+}
+
+func (p *Parser) whileStatement(label *ast.Token) ast.Statement {
+	keyword := p.previous()
 	p.consume(ast.LEFT_BRACKET, "expected '(' after a 'while' statement")
 	condition := p.expression()
 	p.consume(ast.RIGHT_BRACKET, "expected ')' after a 'while' condition")
 	body := p.statement()
-	return &ast.WhileStatement{Condition: condition, Body: body}
+	return &ast.WhileStatement{Keyword: keyword, Condition: condition, Body: body, Label: label}
 }
 
-func (p *Parser) forStatement() ast.Statement {
+func (p *Parser) forStatement(label *ast.Token) ast.Statement {
+	keyword := p.previous()
 	p.consume(ast.LEFT_BRACKET, "expected '(' after a 'for' statement")
 
+	if p.check(ast.IDENTIFIER) && (p.checkAhead(1, ast.IN) || (p.checkAhead(1, ast.COMMA) && p.checkAhead(3, ast.IN))) {
+		return p.forInStatement(label)
+	}
+
 	var initializer ast.Statement
 	if p.match(ast.SEMICOLON) {
 		initializer = nil
@@ -180,18 +379,11 @@ func (p *Parser) forStatement() ast.Statement {
 
 	body := p.statement()
 
-	if increment != nil {
-		body = &ast.BlockStatement{
-			Statements: []ast.Statement{
-				body, &ast.ExpressionStatement{Expression: increment},
-			},
-		}
-	}
-
 	if condition == nil {
 		condition = &ast.Literal{Value: true}
 	}
-	body = &ast.WhileStatement{Condition: condition, Body: body}
+	// The increment stays separate from the body so that 'continue' still runs it
+	body = &ast.WhileStatement{Keyword: keyword, Condition: condition, Body: body, Increment: increment, Label: label}
 
 	if initializer != nil {
 		body = &ast.BlockStatement{
@@ -204,6 +396,20 @@ func (p *Parser) forStatement() ast.Statement {
 	return body
 }
 
+func (p *Parser) forInStatement(label *ast.Token) ast.Statement {
+	variables := []ast.Token{p.consume(ast.IDENTIFIER, "expected a loop variable name")}
+	if p.match(ast.COMMA) {
+		variables = append(variables, p.consume(ast.IDENTIFIER, "expected a second loop variable name after ','"))
+	}
+
+	in := p.consume(ast.IN, "expected 'in' after the loop variables")
+	iterable := p.expression()
+	p.consume(ast.RIGHT_BRACKET, "expected ')' after the 'for' loop iterable")
+
+	body := p.statement()
+	return &ast.ForInStatement{Variables: variables, In: in, Iterable: iterable, Body: body, Label: label}
+}
+
 func (p *Parser) printStatement() ast.Statement {
 	value := p.expression()
 	p.consume(ast.SEMICOLON, "expected ';' after a value")
@@ -227,7 +433,15 @@ func (p *Parser) assignment() ast.Expression {
 			return &ast.Assign{Name: variable.Name, Value: value}
 		}
 
-		errors.Err(equals, "invalid assignment target", p.ErrorHandler)
+		if get, ok := expression.(*ast.Get); ok {
+			return &ast.Set{Object: get.Object, Name: get.Name, Value: value}
+		}
+
+		if index, ok := expression.(*ast.Index); ok {
+			return &ast.IndexSet{Object: index.Object, Bracket: index.Bracket, Index: index.Index, Value: value}
+		}
+
+		p.error(errors.INVALID_ASSIGNMENT_TARGET, equals, equals.Span(), "invalid assignment target", "")
 	}
 
 	return expression
@@ -332,7 +546,7 @@ func (p *Parser) finishCall(callee ast.Expression) ast.Expression {
 	if !p.check(ast.RIGHT_BRACKET) {
 		for {
 			if len(arguments) >= 255 {
-				errors.Err(p.peek(), "can't have more than 255 arguments", p.ErrorHandler)
+				p.error(errors.TOO_MANY_ARGUMENTS, p.peek(), p.peek().Span(), "can't have more than 255 arguments", "")
 			}
 			arguments = append(arguments, p.expression())
 			if !p.match(ast.COMMA) {
@@ -345,12 +559,36 @@ func (p *Parser) finishCall(callee ast.Expression) ast.Expression {
 	return &ast.Call{Callee: callee, Paren: paren, Arguments: arguments}
 }
 
+func (p *Parser) finishIndex(object ast.Expression) ast.Expression {
+	var start ast.Expression
+	if !p.check(ast.COLON) {
+		start = p.expression()
+	}
+
+	if p.match(ast.COLON) {
+		var end ast.Expression
+		if !p.check(ast.RIGHT_SQUARE_BRACKET) {
+			end = p.expression()
+		}
+		bracket := p.consume(ast.RIGHT_SQUARE_BRACKET, "expected ']' after slice")
+		return &ast.Slice{Object: object, Bracket: bracket, Start: start, End: end}
+	}
+
+	bracket := p.consume(ast.RIGHT_SQUARE_BRACKET, "expected ']' after index")
+	return &ast.Index{Object: object, Bracket: bracket, Index: start}
+}
+
 func (p *Parser) call() ast.Expression {
 	expression := p.primary()
 
 	for {
 		if p.match(ast.LEFT_BRACKET) {
 			expression = p.finishCall(expression)
+		} else if p.match(ast.LEFT_SQUARE_BRACKET) {
+			expression = p.finishIndex(expression)
+		} else if p.match(ast.DOT) {
+			name := p.consume(ast.IDENTIFIER, "expected a property name after '.'")
+			expression = &ast.Get{Object: expression, Name: name}
 		} else {
 			break
 		}
@@ -374,17 +612,109 @@ func (p *Parser) primary() ast.Expression {
 		return &ast.Literal{Value: p.previous().Literal}
 	}
 
+	if p.match(ast.INTERPOLATION) {
+		return p.interpolation()
+	}
+
+	if p.match(ast.SUPER) {
+		keyword := p.previous()
+		p.consume(ast.DOT, "expected '.' after 'super'")
+		method := p.consume(ast.IDENTIFIER, "expected a superclass method name")
+		return &ast.Super{Keyword: keyword, Method: method}
+	}
+
+	if p.match(ast.THIS) {
+		return &ast.This{Keyword: p.previous()}
+	}
+
 	if p.match(ast.IDENTIFIER) {
 		return &ast.Variable{Name: p.previous()}
 	}
 
+	if p.match(ast.FUNCTION) {
+		return p.lambda(false)
+	}
+
+	if p.check(ast.LEFT_BRACKET) && p.isArrowFunction() {
+		p.advance()
+		return p.lambda(true)
+	}
+
 	if p.match(ast.LEFT_BRACKET) {
 		expression := p.expression()
 		p.consume(ast.RIGHT_BRACKET, "expected ')' after expression")
 		return &ast.Grouping{Expression: expression}
 	}
 
-	panic(p.throwError(p.peek(), "expected an expression"))
+	if p.match(ast.LEFT_SQUARE_BRACKET) {
+		return p.listLiteral()
+	}
+
+	// A '{' at the start of a statement is always a block (see statement), so here it can only be a map
+	if p.match(ast.LEFT_BRACE) {
+		return p.mapLiteral()
+	}
+
+	panic(p.throwError(errors.EXPECTED_EXPRESSION, p.peek(), "expected an expression"))
+}
+
+func (p *Parser) interpolation() ast.Expression {
+	quote := p.previous()
+	var parts []ast.Expression
+
+	for {
+		if text := p.previous().Literal.(string); text != "" {
+			parts = append(parts, &ast.Literal{Value: text})
+		}
+		parts = append(parts, p.expression())
+
+		if !p.match(ast.INTERPOLATION) {
+			break
+		}
+	}
+
+	end := p.consume(ast.STRING, "expected '}' after an interpolated expression")
+	if text := end.Literal.(string); text != "" {
+		parts = append(parts, &ast.Literal{Value: text})
+	}
+
+	return &ast.Interpolation{Quote: quote, Parts: parts}
+}
+
+func (p *Parser) listLiteral() ast.Expression {
+	var elements []ast.Expression
+
+	if !p.check(ast.RIGHT_SQUARE_BRACKET) {
+		for {
+			elements = append(elements, p.expression())
+			// A trailing comma is fine, which helps with lists split over several lines
+			if !p.match(ast.COMMA) || p.check(ast.RIGHT_SQUARE_BRACKET) {
+				break
+			}
+		}
+	}
+
+	bracket := p.consume(ast.RIGHT_SQUARE_BRACKET, "expected ']' after list elements")
+	return &ast.ListLiteral{Bracket: bracket, Elements: elements}
+}
+
+func (p *Parser) mapLiteral() ast.Expression {
+	var keys, values []ast.Expression
+
+	if !p.check(ast.RIGHT_BRACE) {
+		for {
+			keys = append(keys, p.expression())
+			p.consume(ast.COLON, "expected ':' after a map key")
+			values = append(values, p.expression())
+
+			if !p.match(ast.COMMA) || p.check(ast.RIGHT_BRACE) {
+				break
+			}
+		}
+	}
+
+	brace := p.consume(ast.RIGHT_BRACE, "expected '}' after map entries")
+	return &ast.MapLiteral{Brace: brace, Keys: keys, Values: values}
 }
 
 func (p *Parser) consume(tokentype ast.Type, message string) ast.Token {
@@ -392,14 +722,32 @@ func (p *Parser) consume(tokentype ast.Type, message string) ast.Token {
 		return p.advance()
 	}
 
-	panic(p.throwError(p.peek(), message))
+	// The ';' belongs right after the previous token, which may well be on an earlier line than the next one
+	if tokentype == ast.SEMICOLON {
+		p.error(errors.MISSING_SEMICOLON, p.peek(), p.previous().SpanAfter(), message, "add a ';' at the end of the statement")
+		panic(ParseError{})
+	}
+	panic(p.throwError(errors.EXPECTED_TOKEN, p.peek(), message))
 }
 
-func (p *Parser) throwError(token ast.Token, message string) ParseError {
-	errors.Err(token, message, p.ErrorHandler)
+func (p *Parser) throwError(code errors.Code, token ast.Token, message string) ParseError {
+	p.error(code, token, token.Span(), message, "")
 	return ParseError{}
 }
 
+// error records a syntax error at token, underlining span, without giving up on the statement being parsed.
+func (p *Parser) error(code errors.Code, token ast.Token, span ast.Span, message, help string) {
+	// Whatever the scanner couldn't read has been reported already
+	if token.Type == ast.ILLEGAL {
+		return
+	}
+	// The end of the file is usually a blank line, so point just after the last thing that was written instead
+	if token.Type == ast.EOF && p.current > 0 {
+		span = p.previous().SpanAfter()
+	}
+	p.diagnostics = append(p.diagnostics, errors.At(errors.PARSE, code, token, span, message, help))
+}
+
 type ParseError struct{}
 
 func (p *Parser) synchronize() {
@@ -411,7 +759,7 @@ func (p *Parser) synchronize() {
 		}
 
 		switch p.peek().Type {
-		case ast.CLASS, ast.FUNCTION, ast.VARIABLE, ast.FOR, ast.IF, ast.WHILE, ast.PRINT, ast.RETURN:
+		case ast.CLASS, ast.FUNCTION, ast.VARIABLE, ast.FOR, ast.IF, ast.WHILE, ast.PRINT, ast.RETURN, ast.BREAK, ast.CONTINUE, ast.IMPORT, ast.FROM, ast.THROW, ast.TRY:
 			return
 		}
 
@@ -437,6 +785,14 @@ func (p *Parser) check(tokentype ast.Type) bool {
 	return p.peek().Type == tokentype
 }
 
+// checkAhead looks past the current token without consuming anything, with an offset of 0 being the current token.
+func (p *Parser) checkAhead(offset int, tokentype ast.Type) bool {
+	if p.current+offset >= len(p.Tokens) {
+		return false
+	}
+	return p.Tokens[p.current+offset].Type == tokentype
+}
+
 func (p *Parser) advance() ast.Token {
 	if !p.isAtEnd() {
 		p.current++
@@ -0,0 +1,508 @@
+package resolver
+
+import (
+	"jota/ast"
+	"jota/errors"
+)
+
+type FunctionType int
+
+const (
+	NONE FunctionType = iota
+	FUNCTION
+	INITIALIZER
+	METHOD
+)
+
+type ClassType int
+
+const (
+	NO_CLASS ClassType = iota
+	CLASS
+	SUBCLASS
+)
+
+// Resolver walks the parsed statements once before they are executed and works out where every local variable lives:
+// how many scopes away its declaration is, and at which slot in that scope. It records that in the expression's
+// Binding, and how many variables a scope declares in the statement that opens it. Anything it can't find is treated
+// as global.
+type Resolver struct {
+	scopes          []scope
+	currentFunction FunctionType
+	currentClass    ClassType
+	// The labels of the loops around the code being resolved, innermost last ("" for unlabelled loops)
+	loops       []string
+	diagnostics []errors.Diagnostic
+}
+
+// scope is the variables declared so far in a block, function or other construct that can declare them. A block
+// without any declarations is elided: it still counts as a scope here, but it has no environment at runtime.
+type scope struct {
+	variables map[string]*variable
+	elided    bool
+}
+
+type variable struct {
+	slot int
+	// Whether the variable's initializer has been resolved, so it can be read
+	defined bool
+}
+
+func NewResolver() *Resolver {
+	return &Resolver{}
+}
+
+// Resolve records where the statements' local variables live for the interpreter, and returns every problem found.
+func (r *Resolver) Resolve(statements []ast.Statement) []errors.Diagnostic {
+	r.resolveStatements(statements)
+	return r.diagnostics
+}
+
+func (r *Resolver) VisitBlockStatement(statement *ast.BlockStatement) any {
+	if declarations(statement.Statements) == 0 {
+		r.scopes = append(r.scopes, scope{elided: true})
+		r.resolveStatements(statement.Statements)
+		r.endScope()
+		return nil
+	}
+
+	r.beginScope()
+	r.resolveStatements(statement.Statements)
+	statement.Size = r.endScope()
+	return nil
+}
+
+func (r *Resolver) VisitClassStatement(statement *ast.ClassStatement) any {
+	enclosingClass := r.currentClass
+	r.currentClass = CLASS
+
+	r.declare(statement.Name)
+	r.define(statement.Name)
+
+	if statement.Superclass != nil {
+		if statement.Name.Lexeme == statement.Superclass.Name.Lexeme {
+			r.error(errors.SELF_INHERITANCE, statement.Superclass.Name, "a class can't inherit from itself")
+		}
+
+		r.currentClass = SUBCLASS
+		r.resolveExpression(statement.Superclass)
+
+		r.beginScope()
+		r.defineImplicit("super")
+	}
+
+	r.beginScope()
+	r.defineImplicit("this")
+
+	for _, method := range statement.Methods {
+		declaration := METHOD
+		if method.Name.Lexeme == "init" {
+			declaration = INITIALIZER
+		}
+		r.resolveFunction(method, declaration)
+	}
+
+	r.endScope()
+
+	if statement.Superclass != nil {
+		r.endScope()
+	}
+
+	r.currentClass = enclosingClass
+	return nil
+}
+
+func (r *Resolver) VisitExpressionStatement(statement *ast.ExpressionStatement) any {
+	r.resolveExpression(statement.Expression)
+	return nil
+}
+
+func (r *Resolver) VisitFunctionStatement(statement *ast.FunctionStatement) any {
+	r.declare(statement.Name)
+	r.define(statement.Name)
+
+	r.resolveFunction(statement, FUNCTION)
+	return nil
+}
+
+func (r *Resolver) VisitIfStatement(statement *ast.IfStatement) any {
+	r.resolveExpression(statement.Condition)
+	r.resolveStatement(statement.ThenBranch)
+	if statement.ElseBranch != nil {
+		r.resolveStatement(statement.ElseBranch)
+	}
+	return nil
+}
+
+func (r *Resolver) VisitPrintStatement(statement *ast.PrintStatement) any {
+	r.resolveExpression(statement.Expression)
+	return nil
+}
+
+func (r *Resolver) VisitReturnStatement(statement *ast.ReturnStatement) any {
+	if r.currentFunction == NONE {
+		r.error(errors.TOP_LEVEL_RETURN, statement.Keyword, "can't return from top-level code")
+	}
+
+	if statement.Value != nil {
+		if r.currentFunction == INITIALIZER {
+			r.error(errors.INITIALIZER_RETURN, statement.Keyword, "can't return a value from an initializer")
+		}
+		r.resolveExpression(statement.Value)
+	}
+	return nil
+}
+
+func (r *Resolver) VisitVariableStatement(statement *ast.VariableStatement) any {
+	r.declare(statement.Name)
+	if statement.Initializer != nil {
+		r.resolveExpression(statement.Initializer)
+	}
+	r.define(statement.Name)
+	return nil
+}
+
+func (r *Resolver) VisitWhileStatement(statement *ast.WhileStatement) any {
+	r.resolveExpression(statement.Condition)
+	r.resolveLoopBody(statement.Body, statement.Label)
+	if statement.Increment != nil {
+		r.resolveExpression(statement.Increment)
+	}
+	return nil
+}
+
+func (r *Resolver) VisitBreakStatement(statement *ast.BreakStatement) any {
+	r.checkLoopJump(statement.Keyword, statement.Label)
+	return nil
+}
+
+func (r *Resolver) VisitContinueStatement(statement *ast.ContinueStatement) any {
+	r.checkLoopJump(statement.Keyword, statement.Label)
+	return nil
+}
+
+func (r *Resolver) VisitThrowStatement(statement *ast.ThrowStatement) any {
+	r.resolveExpression(statement.Value)
+	return nil
+}
+
+// The caught error's name lives in the same scope as the catch block's own variables
+func (r *Resolver) VisitTryStatement(statement *ast.TryStatement) any {
+	r.resolveStatement(statement.Body)
+
+	if statement.Catch != nil {
+		r.beginScope()
+		if statement.CatchName != nil {
+			r.declare(*statement.CatchName)
+			r.define(*statement.CatchName)
+		}
+		r.resolveStatements(statement.Catch.Statements)
+		// The catch block's size counts the caught error, which it gets its environment for even if it declares nothing
+		statement.Catch.Size = r.endScope()
+	}
+
+	if statement.Finally != nil {
+		r.resolveStatement(statement.Finally)
+	}
+	return nil
+}
+
+// Imports bind globals, so they're only allowed outside of any block or function
+func (r *Resolver) VisitImportStatement(statement *ast.ImportStatement) any {
+	if len(r.scopes) > 0 {
+		r.error(errors.NESTED_IMPORT, statement.Keyword, "imports are only allowed at the top level of a file")
+	}
+	return nil
+}
+
+func (r *Resolver) VisitForInStatement(statement *ast.ForInStatement) any {
+	r.resolveExpression(statement.Iterable)
+
+	r.beginScope()
+	for _, variable := range statement.Variables {
+		r.declare(variable)
+		r.define(variable)
+	}
+	r.resolveLoopBody(statement.Body, statement.Label)
+	r.endScope()
+	return nil
+}
+
+func (r *Resolver) VisitAssignExpression(expression *ast.Assign) any {
+	r.resolveExpression(expression.Value)
+	expression.Binding = r.resolveLocal(expression.Name)
+	return nil
+}
+
+func (r *Resolver) VisitBinaryExpression(expression *ast.Binary) any {
+	r.resolveExpression(expression.Left)
+	r.resolveExpression(expression.Right)
+	return nil
+}
+
+func (r *Resolver) VisitCallExpression(expression *ast.Call) any {
+	r.resolveExpression(expression.Callee)
+	for _, argument := range expression.Arguments {
+		r.resolveExpression(argument)
+	}
+	return nil
+}
+
+func (r *Resolver) VisitGetExpression(expression *ast.Get) any {
+	r.resolveExpression(expression.Object)
+	return nil
+}
+
+func (r *Resolver) VisitGroupingExpression(expression *ast.Grouping) any {
+	r.resolveExpression(expression.Expression)
+	return nil
+}
+
+func (r *Resolver) VisitInterpolationExpression(expression *ast.Interpolation) any {
+	for _, part := range expression.Parts {
+		r.resolveExpression(part)
+	}
+	return nil
+}
+
+func (r *Resolver) VisitListLiteralExpression(expression *ast.ListLiteral) any {
+	for _, element := range expression.Elements {
+		r.resolveExpression(element)
+	}
+	return nil
+}
+
+func (r *Resolver) VisitMapLiteralExpression(expression *ast.MapLiteral) any {
+	for index := range expression.Keys {
+		r.resolveExpression(expression.Keys[index])
+		r.resolveExpression(expression.Values[index])
+	}
+	return nil
+}
+
+func (r *Resolver) VisitIndexExpression(expression *ast.Index) any {
+	r.resolveExpression(expression.Object)
+	r.resolveExpression(expression.Index)
+	return nil
+}
+
+func (r *Resolver) VisitIndexSetExpression(expression *ast.IndexSet) any {
+	r.resolveExpression(expression.Object)
+	r.resolveExpression(expression.Index)
+	r.resolveExpression(expression.Value)
+	return nil
+}
+
+func (r *Resolver) VisitSliceExpression(expression *ast.Slice) any {
+	r.resolveExpression(expression.Object)
+	if expression.Start != nil {
+		r.resolveExpression(expression.Start)
+	}
+	if expression.End != nil {
+		r.resolveExpression(expression.End)
+	}
+	return nil
+}
+
+func (r *Resolver) VisitLambdaExpression(expression *ast.Lambda) any {
+	r.resolveFunction(expression.Declaration, FUNCTION)
+	return nil
+}
+
+func (r *Resolver) VisitLiteralExpression(expression *ast.Literal) any {
+	return nil
+}
+
+func (r *Resolver) VisitLogicalExpression(expression *ast.Logical) any {
+	r.resolveExpression(expression.Left)
+	r.resolveExpression(expression.Right)
+	return nil
+}
+
+func (r *Resolver) VisitSetExpression(expression *ast.Set) any {
+	r.resolveExpression(expression.Value)
+	r.resolveExpression(expression.Object)
+	return nil
+}
+
+func (r *Resolver) VisitSuperExpression(expression *ast.Super) any {
+	if r.currentClass == NO_CLASS {
+		r.error(errors.SUPER_OUTSIDE_CLASS, expression.Keyword, "can't use 'super' outside of a class")
+	} else if r.currentClass != SUBCLASS {
+		r.error(errors.SUPER_WITHOUT_SUPERCLASS, expression.Keyword, "can't use 'super' in a class with no superclass")
+	}
+
+	expression.Binding = r.resolveLocal(expression.Keyword)
+	return nil
+}
+
+func (r *Resolver) VisitThisExpression(expression *ast.This) any {
+	if r.currentClass == NO_CLASS {
+		r.error(errors.THIS_OUTSIDE_CLASS, expression.Keyword, "can't use 'this' outside of a class")
+		return nil
+	}
+
+	expression.Binding = r.resolveLocal(expression.Keyword)
+	return nil
+}
+
+func (r *Resolver) VisitUnaryExpression(expression *ast.Unary) any {
+	r.resolveExpression(expression.Right)
+	return nil
+}
+
+func (r *Resolver) VisitVariableExpression(expression *ast.Variable) any {
+	if len(r.scopes) > 0 {
+		if variable, declared := r.peekScope().variables[expression.Name.Lexeme]; declared && !variable.defined {
+			r.error(errors.SELF_INITIALIZER, expression.Name, "can't read a local variable in its own initializer")
+		}
+	}
+
+	expression.Binding = r.resolveLocal(expression.Name)
+	return nil
+}
+
+func (r *Resolver) resolveStatements(statements []ast.Statement) {
+	for _, statement := range statements {
+		// The parser leaves nils behind for declarations it couldn't parse
+		if statement == nil {
+			continue
+		}
+		r.resolveStatement(statement)
+	}
+}
+
+func (r *Resolver) resolveStatement(statement ast.Statement) {
+	// The parser leaves a nil behind for every declaration it had to recover from
+	if statement == nil {
+		return
+	}
+	statement.Accept(r)
+}
+
+func (r *Resolver) resolveExpression(expression ast.Expression) {
+	expression.Accept(r)
+}
+
+func (r *Resolver) resolveLoopBody(body ast.Statement, label *ast.Token) {
+	name := ""
+	if label != nil {
+		name = label.Lexeme
+	}
+
+	r.loops = append(r.loops, name)
+	r.resolveStatement(body)
+	r.loops = r.loops[:len(r.loops)-1]
+}
+
+// checkLoopJump makes sure a break or continue has a loop to jump out of, and that its label (if any) exists.
+func (r *Resolver) checkLoopJump(keyword ast.Token, label *ast.Token) {
+	if len(r.loops) == 0 {
+		r.error(errors.JUMP_OUTSIDE_LOOP, keyword, "can't use '"+keyword.Lexeme+"' outside of a loop")
+		return
+	}
+
+	if label == nil {
+		return
+	}
+
+	for _, loop := range r.loops {
+		if loop == label.Lexeme {
+			return
+		}
+	}
+	r.error(errors.UNKNOWN_LABEL, *label, "there's no enclosing loop labelled '"+label.Lexeme+"'")
+}
+
+func (r *Resolver) resolveFunction(function *ast.FunctionStatement, functionType FunctionType) {
+	enclosingFunction := r.currentFunction
+	r.currentFunction = functionType
+
+	// Loops outside the function can't be broken out of from inside it
+	enclosingLoops := r.loops
+	r.loops = nil
+
+	r.beginScope()
+	for _, param := range function.Params {
+		r.declare(param)
+		r.define(param)
+	}
+	r.resolveStatements(function.Body)
+	function.Size = r.endScope()
+
+	r.currentFunction = enclosingFunction
+	r.loops = enclosingLoops
+}
+
+// resolveLocal finds the innermost scope declaring name. Without one, the name is global.
+func (r *Resolver) resolveLocal(name ast.Token) ast.Binding {
+	depth := 0
+	for i := len(r.scopes) - 1; i >= 0; i-- {
+		if variable, ok := r.scopes[i].variables[name.Lexeme]; ok {
+			return ast.Binding{Local: true, Depth: depth, Slot: variable.slot}
+		}
+		// Elided blocks have no environment to step over
+		if !r.scopes[i].elided {
+			depth++
+		}
+	}
+	return ast.Binding{}
+}
+
+func (r *Resolver) beginScope() {
+	r.scopes = append(r.scopes, scope{variables: make(map[string]*variable)})
+}
+
+// endScope closes the innermost scope and returns how many variables it declared.
+func (r *Resolver) endScope() int {
+	size := len(r.peekScope().variables)
+	r.scopes = r.scopes[:len(r.scopes)-1]
+	return size
+}
+
+func (r *Resolver) peekScope() scope {
+	return r.scopes[len(r.scopes)-1]
+}
+
+func (r *Resolver) declare(name ast.Token) {
+	if len(r.scopes) == 0 {
+		return
+	}
+
+	scope := r.peekScope()
+	if _, ok := scope.variables[name.Lexeme]; ok {
+		r.error(errors.DUPLICATE_VARIABLE, name, "a variable with this name already exists in this scope")
+		return
+	}
+	scope.variables[name.Lexeme] = &variable{slot: len(scope.variables)}
+}
+
+func (r *Resolver) define(name ast.Token) {
+	if len(r.scopes) == 0 {
+		return
+	}
+	if variable, ok := r.peekScope().variables[name.Lexeme]; ok {
+		variable.defined = true
+	}
+}
+
+// defineImplicit declares 'this' or 'super', which always have a scope of their own.
+func (r *Resolver) defineImplicit(name string) {
+	r.peekScope().variables[name] = &variable{slot: 0, defined: true}
+}
+
+// declarations counts the variables a block declares directly, which are all it needs an environment for.
+func declarations(statements []ast.Statement) int {
+	count := 0
+	for _, statement := range statements {
+		switch statement.(type) {
+		case *ast.VariableStatement, *ast.FunctionStatement, *ast.ClassStatement:
+			count++
+		}
+	}
+	return count
+}
+
+func (r *Resolver) error(code errors.Code, token ast.Token, message string) {
+	r.diagnostics = append(r.diagnostics, errors.At(errors.RESOLVE, code, token, token.Span(), message, ""))
+}
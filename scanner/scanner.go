@@ -4,48 +4,81 @@ import (
 	"jota/ast"
 	"jota/errors"
 	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 var (
 	keywords = map[string]ast.Type{
+		"as":       ast.AS,
+		"break":    ast.BREAK,
+		"catch":    ast.CATCH,
 		"class":    ast.CLASS,
+		"continue": ast.CONTINUE,
 		"else":     ast.ELSE,
 		"false":    ast.FALSE,
+		"finally":  ast.FINALLY,
 		"for":      ast.FOR,
+		"from":     ast.FROM,
 		"function": ast.FUNCTION,
 		"if":       ast.IF,
+		"import":   ast.IMPORT,
+		"in":       ast.IN,
 		"nil":      ast.NIL,
 		"print":    ast.PRINT,
 		"return":   ast.RETURN,
 		"super":    ast.SUPER,
 		"this":     ast.THIS,
+		"throw":    ast.THROW,
 		"true":     ast.TRUE,
+		"try":      ast.TRY,
 		"assign":   ast.VARIABLE,
 		"while":    ast.WHILE,
 	}
 )
 
+// Scanner works on runes rather than bytes, so UTF-8 text in strings and identifiers is handled as whole characters.
 type Scanner struct {
-	source string
+	source []rune
 	tokens []ast.Token
 
-	errorHandler errors.ErrorHandler
+	diagnostics []errors.Diagnostic
+
+	// file is shared by every token, so diagnostics can quote the line a token came from
+	file *ast.Source
 
 	start, current, line int
+	// lineStart is where the current line begins, offset the byte position of current, and startLine, startColumn and
+	// startOffset are where the token being scanned began
+	lineStart, offset                   int
+	startLine, startColumn, startOffset int
+
+	// interpolations holds, for each "${" we are currently inside of, how many unclosed '{' it contains. The '}' that
+	// brings a count back below zero ends the embedded expression and resumes the string.
+	interpolations []int
 }
 
-func CreateScanner(source string, errorHandler errors.ErrorHandler) *Scanner {
-	return &Scanner{source: source, start: 0, current: 0, line: 1, errorHandler: errorHandler}
+// CreateScanner scans source, which was read from the named file (name is empty for code typed into the REPL).
+func CreateScanner(source string, name string) *Scanner {
+	file := &ast.Source{Name: name, Text: source}
+	return &Scanner{source: []rune(source), file: file, start: 0, current: 0, line: 1}
 }
 
-func (s *Scanner) ScanTokens() []ast.Token {
+// ScanTokens returns the tokens in the source, along with every problem found on the way.
+func (s *Scanner) ScanTokens() ([]ast.Token, []errors.Diagnostic) {
 	for !s.isAtEnd() {
-		s.start = s.current
+		s.markStart()
 		s.scanToken()
 	}
 
-	s.tokens = append(s.tokens, ast.Token{Type: ast.EOF, Lexeme: "", Literal: nil, Line: s.line})
-	return s.tokens
+	s.markStart()
+	if len(s.interpolations) > 0 {
+		s.error(errors.UNTERMINATED_STRING, s.startSpan(), "unterminated string interpolation", "close the interpolation with '}' and the string with '\"'")
+	}
+
+	s.addToken(ast.EOF)
+	return s.tokens, s.diagnostics
 }
 
 func (s *Scanner) scanToken() {
@@ -57,11 +90,28 @@ func (s *Scanner) scanToken() {
 	case ')':
 		s.addToken(ast.RIGHT_BRACKET)
 	case '{':
+		if depth := len(s.interpolations); depth > 0 {
+			s.interpolations[depth-1]++
+		}
 		s.addToken(ast.LEFT_BRACE)
 	case '}':
+		if depth := len(s.interpolations); depth > 0 {
+			if s.interpolations[depth-1] == 0 {
+				s.interpolations = s.interpolations[:depth-1]
+				s.string()
+				return
+			}
+			s.interpolations[depth-1]--
+		}
 		s.addToken(ast.RIGHT_BRACE)
+	case '[':
+		s.addToken(ast.LEFT_SQUARE_BRACKET)
+	case ']':
+		s.addToken(ast.RIGHT_SQUARE_BRACKET)
 	case ',':
 		s.addToken(ast.COMMA)
+	case ':':
+		s.addToken(ast.COLON)
 	case '.':
 		s.addToken(ast.DOT)
 	case '-':
@@ -86,13 +136,13 @@ func (s *Scanner) scanToken() {
 		if s.match('&') {
 			s.addToken(ast.AND)
 		} else {
-			errors.ErrWithoutToken(s.line, "unexpected character found", s.errorHandler)
+			s.illegal("use '&&' for a logical and")
 		}
 	case '|':
 		if s.match('|') {
 			s.addToken(ast.OR)
 		} else {
-			errors.ErrWithoutToken(s.line, "unexpected character found", s.errorHandler)
+			s.illegal("use '||' for a logical or")
 		}
 	case '!':
 		if s.match('=') {
@@ -103,6 +153,8 @@ func (s *Scanner) scanToken() {
 	case '=':
 		if s.match('=') {
 			s.addToken(ast.EQUAL_EQUAL)
+		} else if s.match('>') {
+			s.addToken(ast.ARROW)
 		} else {
 			s.addToken(ast.EQUAL)
 		}
@@ -126,18 +178,18 @@ func (s *Scanner) scanToken() {
 		for s.peek() != '\n' && !s.isAtEnd() {
 			s.advance()
 		}
-	case ' ', '\r', '\t':
-	case '\n':
-		s.line++
+	case ' ', '\r', '\t', '\n':
 	case '"':
 		s.string()
+	case '`':
+		s.rawString()
 	default:
 		if isDigit(char) {
 			s.number()
 		} else if s.isAlpha(char) {
 			s.identifier()
 		} else {
-			errors.ErrWithoutToken(s.line, "unexpected character found", s.errorHandler)
+			s.illegal("")
 		}
 	}
 }
@@ -146,7 +198,7 @@ func (s *Scanner) identifier() {
 	for s.isAlphaNumeric(s.peek()) {
 		s.advance()
 	}
-	text := s.source[s.start:s.current]
+	text := string(s.source[s.start:s.current])
 	token, found := keywords[text]
 	if !found {
 		token = ast.IDENTIFIER
@@ -167,76 +219,172 @@ func (s *Scanner) number() {
 		}
 	}
 
-	num, err := strconv.ParseFloat(s.source[s.start:s.current], 64)
+	num, err := strconv.ParseFloat(string(s.source[s.start:s.current]), 64)
 
 	if err != nil {
-		errors.ErrWithoutToken(s.line, "scanner has an issue parsing a number", s.errorHandler)
+		s.error(errors.INVALID_NUMBER, s.startSpan(), "scanner has an issue parsing a number", "")
 	}
 
 	s.addTokenWithLiteral(ast.NUMBER, num)
 }
 
+// string scans a double-quoted string, or the rest of one after an interpolated expression. Every "${" ends the
+// current part as an INTERPOLATION token, and the last part ends up as a STRING.
 func (s *Scanner) string() {
+	var value strings.Builder
 	for s.peek() != '"' && !s.isAtEnd() {
-		if s.peek() == '\n' {
-			s.line++
+		char := s.advance()
+		if char == '\\' && !s.isAtEnd() {
+			s.escapeSequence(&value)
+			continue
 		}
+		if char == '$' && s.match('{') {
+			s.addTokenWithLiteral(ast.INTERPOLATION, value.String())
+			s.interpolations = append(s.interpolations, 0)
+			return
+		}
+		value.WriteRune(char)
+	}
+
+	if s.isAtEnd() {
+		s.error(errors.UNTERMINATED_STRING, s.startSpan(), "unterminated string", "add a closing '\"' at the end of the string")
+		s.addToken(ast.ILLEGAL)
+		return
+	}
+
+	s.advance()
+	s.addTokenWithLiteral(ast.STRING, value.String())
+}
+
+// escapeSequence decodes what follows a backslash inside a string, writing the resulting character to value.
+func (s *Scanner) escapeSequence(value *strings.Builder) {
+	// The span covers the backslash and whatever follows it
+	span := s.spanFrom(s.current-1, s.offset-1)
+	char := s.advance()
+	switch char {
+	case 'n':
+		value.WriteRune('\n')
+	case 't':
+		value.WriteRune('\t')
+	case 'r':
+		value.WriteRune('\r')
+	case '0':
+		value.WriteRune(0)
+	case '\\', '"', '`', '$':
+		value.WriteRune(char)
+	case 'u':
+		s.unicodeEscape(value)
+	default:
+		span.Length = 1 + utf8.RuneLen(char)
+		s.error(errors.INVALID_ESCAPE, span, "unknown escape sequence '\\"+string(char)+"'", "write '\\\\' for a backslash")
+	}
+}
+
+// unicodeEscape decodes \u{...}, which holds between 1 and 6 hex digits naming a Unicode code point.
+func (s *Scanner) unicodeEscape(value *strings.Builder) {
+	// "\\u" has already been consumed
+	span := s.spanFrom(s.current-2, s.offset-2)
+	if !s.match('{') {
+		span.Length = 2
+		s.error(errors.INVALID_ESCAPE, span, "expected '{' after '\\u'", "write the code point as '\\u{1F600}'")
+		return
+	}
+
+	start := s.current
+	for isHexDigit(s.peek()) {
+		s.advance()
+	}
+	digits := string(s.source[start:s.current])
+
+	if !s.match('}') {
+		span.Length = s.offset - span.Offset
+		s.error(errors.INVALID_ESCAPE, span, "expected '}' to close the '\\u{' escape sequence", "")
+		return
+	}
+
+	span.Length = s.offset - span.Offset
+	code, err := strconv.ParseUint(digits, 16, 32)
+	if len(digits) == 0 || len(digits) > 6 || err != nil || code > unicode.MaxRune || (code >= 0xD800 && code <= 0xDFFF) {
+		s.error(errors.INVALID_ESCAPE, span, "'\\u{"+digits+"}' is not a valid Unicode character", "")
+		return
+	}
+	value.WriteRune(rune(code))
+}
+
+// rawString scans a backtick-delimited string, which has no escape sequences and keeps its newlines as-is.
+func (s *Scanner) rawString() {
+	for s.peek() != '`' && !s.isAtEnd() {
 		s.advance()
 	}
 
 	if s.isAtEnd() {
-		errors.ErrWithoutToken(s.line, "unterminated string", s.errorHandler)
+		s.error(errors.UNTERMINATED_STRING, s.startSpan(), "unterminated raw string", "add a closing '`' at the end of the string")
+		s.addToken(ast.ILLEGAL)
 		return
 	}
 
 	s.advance()
 
-	value := s.source[s.start+1 : s.current-1]
+	value := string(s.source[s.start+1 : s.current-1])
+	// Windows line endings would otherwise leak into the string
+	value = strings.ReplaceAll(value, "\r\n", "\n")
 	s.addTokenWithLiteral(ast.STRING, value)
 }
 
-func (s *Scanner) advance() byte {
+// advance is the only place that moves past a character (besides match), so it also keeps the line count.
+func (s *Scanner) advance() rune {
+	char := s.source[s.current]
 	s.current++
-	return s.source[s.current-1]
+	s.offset += utf8.RuneLen(char)
+	if char == '\n' {
+		s.line++
+		s.lineStart = s.current
+	}
+	return char
 }
 
-func (s *Scanner) peek() byte {
+func (s *Scanner) peek() rune {
 	if s.isAtEnd() {
 		return 0
 	}
 	return s.source[s.current]
 }
 
-func (s *Scanner) peekNext() byte {
+func (s *Scanner) peekNext() rune {
 	if s.current+1 >= len(s.source) {
 		return 0
 	}
 	return s.source[s.current+1]
 }
 
-func (s *Scanner) match(expected byte) bool {
+func (s *Scanner) match(expected rune) bool {
 	if s.isAtEnd() {
 		return false
 	}
 	if s.source[s.current] != expected {
 		return false
 	}
-	s.current++
+	s.advance()
 	return true
 }
 
-func (s *Scanner) isAlpha(char byte) bool {
-	return (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || (char == '_')
+// Identifiers can use letters from any script, not just ASCII ones
+func (s *Scanner) isAlpha(char rune) bool {
+	return unicode.IsLetter(char) || (char == '_')
 }
 
-func (s *Scanner) isAlphaNumeric(char byte) bool {
-	return s.isAlpha(char) || isDigit(char)
+func (s *Scanner) isAlphaNumeric(char rune) bool {
+	return s.isAlpha(char) || unicode.IsDigit(char)
 }
 
-func isDigit(char byte) bool {
+func isDigit(char rune) bool {
 	return char >= '0' && char <= '9'
 }
 
+func isHexDigit(char rune) bool {
+	return isDigit(char) || (char >= 'a' && char <= 'f') || (char >= 'A' && char <= 'F')
+}
+
 func (s *Scanner) isAtEnd() bool {
 	return s.current >= len(s.source)
 }
@@ -246,6 +394,41 @@ func (s *Scanner) addToken(tokenType ast.Type) {
 }
 
 func (s *Scanner) addTokenWithLiteral(tokenType ast.Type, literal any) {
-	text := s.source[s.start:s.current]
-	s.tokens = append(s.tokens, ast.Token{Type: tokenType, Lexeme: text, Literal: literal, Line: s.line})
+	text := string(s.source[s.start:s.current])
+	s.tokens = append(s.tokens, ast.Token{
+		Type: tokenType, Lexeme: text, Literal: literal,
+		Line: s.startLine, Column: s.startColumn, Offset: s.startOffset, Source: s.file,
+	})
+}
+
+// markStart records where the next token begins.
+func (s *Scanner) markStart() {
+	s.start = s.current
+	s.startLine = s.line
+	s.startColumn = s.current - s.lineStart + 1
+	s.startOffset = s.offset
+}
+
+// startSpan covers everything scanned since the start of the current token.
+func (s *Scanner) startSpan() ast.Span {
+	return ast.Span{
+		Source: s.file, Line: s.startLine, Column: s.startColumn, Offset: s.startOffset, Length: s.offset - s.startOffset,
+	}
+}
+
+// spanFrom is an empty span at the given rune and byte positions, which must be on the current line.
+func (s *Scanner) spanFrom(position, offset int) ast.Span {
+	return ast.Span{Source: s.file, Line: s.line, Column: position - s.lineStart + 1, Offset: offset}
+}
+
+func (s *Scanner) error(code errors.Code, span ast.Span, message, help string) {
+	s.diagnostics = append(s.diagnostics, errors.Diagnostic{Kind: errors.SCAN, Code: code, Span: span, Message: message, Help: help})
+}
+
+// illegal reports the character just scanned as one that can't start a token, and leaves an ILLEGAL token in its
+// place so the parser doesn't report the gap it leaves as well.
+func (s *Scanner) illegal(help string) {
+	s.addToken(ast.ILLEGAL)
+	token := s.tokens[len(s.tokens)-1]
+	s.diagnostics = append(s.diagnostics, errors.At(errors.SCAN, errors.UNEXPECTED_CHARACTER, token, token.Span(), "unexpected character", help))
 }
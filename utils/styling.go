@@ -1,13 +1,80 @@
 package utils
 
+import (
+	"os"
+)
+
 const (
-	Blue    = "\x1b[34m"
-	Yellow  = "\x1b[33m"
-	Green   = "\x1b[32m"
-	Magenta = "\x1b[35m"
-	Red     = "\x1b[31m"
-	Black   = "\x1b[30m"
-	White   = "\x1b[37m"
-	Cyan    = "\x1b[36m"
-	Reset   = "\x1b[0m"
+	blue    = "\x1b[34m"
+	yellow  = "\x1b[33m"
+	green   = "\x1b[32m"
+	magenta = "\x1b[35m"
+	red     = "\x1b[31m"
+	black   = "\x1b[30m"
+	white   = "\x1b[37m"
+	cyan    = "\x1b[36m"
+	reset   = "\x1b[0m"
+)
+
+// Role is what a piece of output is for. A Theme decides which color each role gets.
+type Role int
+
+const (
+	ERROR Role = iota
+	// TEXT is the body of a message, after its colored label
+	TEXT
+	USAGE
+	SUCCESS
+	HINT
+	SUGGESTION
+	LINK
+)
+
+type Theme map[Role]string
+
+var (
+	// DarkTheme is for light text on a dark background, which most terminals default to
+	DarkTheme = Theme{ERROR: red, TEXT: white, USAGE: yellow, SUCCESS: green, HINT: cyan, SUGGESTION: magenta, LINK: blue}
+	// LightTheme swaps out the colors that are hard to read on a light background
+	LightTheme = Theme{ERROR: red, TEXT: black, USAGE: magenta, SUCCESS: green, HINT: blue, SUGGESTION: magenta, LINK: blue}
 )
+
+// Style colors text written to one stream, or leaves it plain if that stream shouldn't get colors. The zero Style
+// is plain.
+type Style struct {
+	theme Theme
+}
+
+// NewStyle picks the style for writing to file. The mode is "always", "never" or "auto", which only colors
+// terminals, and only if NO_COLOR isn't set.
+func NewStyle(file *os.File, mode string, theme Theme) Style {
+	switch mode {
+	case "always":
+		return Style{theme: theme}
+	case "never":
+		return Style{}
+	}
+
+	// See https://no-color.org
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" || !isTerminal(file) {
+		return Style{}
+	}
+	return Style{theme: theme}
+}
+
+// Paint colors text for the given role.
+func (s Style) Paint(role Role, text string) string {
+	if s.theme == nil || text == "" {
+		return text
+	}
+	return s.theme[role] + text + reset
+}
+
+// isTerminal is true for character devices, which is what terminals are (and what pipes and files aren't).
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,28 @@
+package vm
+
+import (
+	"jota/builtins"
+)
+
+func (vm *VM) defineNatives() {
+	for _, native := range builtins.Natives {
+		vm.DefineNative(native)
+	}
+}
+
+// DefineNative makes a native function visible to the main script and every module, like the built-in ones. If it
+// needs a capability the VM wasn't given, calling it is a permission error.
+func (vm *VM) DefineNative(native builtins.Native) {
+	native = vm.capabilities.Guard(native)
+	vm.natives[native.Name] = &native
+}
+
+// Global reads one of the main script's global variables.
+func (vm *VM) Global(name string) (any, bool) {
+	value, ok := vm.globals[name]
+	return value, ok
+}
+
+func (vm *VM) SetGlobal(name string, value any) {
+	vm.globals[name] = value
+}
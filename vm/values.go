@@ -0,0 +1,68 @@
+package vm
+
+import (
+	"jota/compiler"
+)
+
+type Closure struct {
+	Function *compiler.Function
+	Upvalues []*Upvalue
+	// Globals belongs to the module the closure was created in, which its global names refer to
+	Globals map[string]any
+}
+
+func (c *Closure) TypeName() string {
+	return "function"
+}
+
+func (c *Closure) String() string {
+	return c.Function.String()
+}
+
+// Upvalue is a variable captured by a closure. While the variable is still on the stack, Location points at its
+// slot. Once the slot is popped the value moves into Closed and Location points there instead.
+type Upvalue struct {
+	Location *any
+	Closed   any
+	slot     int
+	next     *Upvalue
+}
+
+type Class struct {
+	Name    string
+	Methods map[string]*Closure
+}
+
+func (c *Class) TypeName() string {
+	return "class"
+}
+
+func (c *Class) String() string {
+	return "<class " + c.Name + ">"
+}
+
+type Instance struct {
+	Class  *Class
+	Fields map[string]any
+}
+
+func (in *Instance) TypeName() string {
+	return "instance"
+}
+
+func (in *Instance) String() string {
+	return "<" + in.Class.Name + " instance>"
+}
+
+type BoundMethod struct {
+	Receiver any
+	Method   *Closure
+}
+
+func (bm *BoundMethod) TypeName() string {
+	return "function"
+}
+
+func (bm *BoundMethod) String() string {
+	return bm.Method.String()
+}
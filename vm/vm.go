@@ -0,0 +1,697 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"jota/ast"
+	"jota/builtins"
+	"jota/compiler"
+	"jota/errors"
+	"jota/modules"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// STACK_INITIAL is how many slots the stack starts out with. It grows as calls nest, which the depth limit bounds.
+const STACK_INITIAL = 1024
+
+type CallFrame struct {
+	closure *Closure
+	ip      int
+	slots   int
+}
+
+// handler is an open try block: where its code resumes when an error is caught, and the frame and stack height to
+// unwind back to first.
+type handler struct {
+	frameCount int
+	stackTop   int
+	ip         int
+}
+
+// VM executes the bytecode produced by the compiler package. Globals survive between calls to Interpret so the
+// REPL keeps its state, just like the tree-walking interpreter does.
+type VM struct {
+	Errors errors.Sink
+	// File is the path of the file being run ("" in the REPL), which imports are resolved relative to
+	File string
+	// Where print writes to and input would read from, the process' own streams by default. Errors go to the Sink.
+	Stdout io.Writer
+	Stdin  io.Reader
+	// Limits apply to every run from now on, each one getting a fresh budget
+	Limits builtins.Limits
+
+	// frames has room for as many calls as the limits allow, and is only reallocated when they change
+	frames       []CallFrame
+	frameCount   int
+	stack        []any
+	stackTop     int
+	globals      map[string]any
+	natives      map[string]any
+	openUpvalues *Upvalue
+	handlers     []handler
+	modules      *modules.Loader
+	capabilities builtins.Capabilities
+	budget       *builtins.Budget
+}
+
+// NewVM makes a VM whose scripts can only do what capabilities allows, like reading files.
+func NewVM(sink errors.Sink, capabilities builtins.Capabilities) *VM {
+	vm := &VM{Errors: sink, Stdout: os.Stdout, Stdin: os.Stdin, stack: make([]any, STACK_INITIAL), globals: make(map[string]any), natives: make(map[string]any), modules: modules.NewLoader(capabilities), capabilities: capabilities}
+	vm.defineNatives()
+	return vm
+}
+
+// Interpret runs a compiled script, reporting a runtime error to the Sink if one stops it. Going over a limit or ctx
+// being canceled is reported too, and also returned (as a LimitError) because it's the host's doing, not the script's.
+func (vm *VM) Interpret(ctx context.Context, function *compiler.Function) (err error) {
+	vm.budget = vm.Limits.Start(ctx)
+	if depth := vm.Limits.Depth(); len(vm.frames) != depth {
+		vm.frames = make([]CallFrame, depth)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(builtins.Error); ok {
+				r = errors.RuntimeError{Token: vm.currentToken(), Code: e.Code, Message: e.Message}
+			}
+			switch e := r.(type) {
+			case errors.RuntimeError:
+				vm.Errors.ReportRuntimeError(vm.withStack(e))
+			case errors.LimitError:
+				e.RuntimeError = vm.withStack(e.RuntimeError)
+				vm.Errors.ReportRuntimeError(e.RuntimeError)
+				err = e
+			default:
+				panic(r)
+			}
+			vm.resetStack()
+		}
+	}()
+
+	closure := &Closure{Function: function, Globals: vm.globals}
+	vm.push(closure)
+	vm.call(closure, 0)
+	vm.run(0)
+	vm.pop()
+	return nil
+}
+
+// Call lets natives call back into script code. The callee runs to completion on top of the current frames.
+func (vm *VM) Call(callee any, arguments []any) any {
+	vm.push(callee)
+	for _, argument := range arguments {
+		vm.push(argument)
+	}
+
+	depth := vm.frameCount
+	vm.callValue(callee, len(arguments))
+	// Natives (and classes without an initializer) finish right away, everything else pushed a frame to run
+	if vm.frameCount > depth {
+		vm.run(depth)
+	}
+	return vm.pop()
+}
+
+// runModule evaluates an imported file with its own globals and returns them.
+func (vm *VM) runModule(path, source string) map[string]any {
+	statements := modules.Parse(path, source, vm.Errors)
+	function := compiler.Compile(statements, path, vm.Errors)
+	if function == nil {
+		panic(builtins.Error{Code: errors.IMPORT_FAILED, Message: "module '" + filepath.Base(path) + "' has compile errors"})
+	}
+
+	previousFile := vm.File
+	defer func() {
+		vm.File = previousFile
+	}()
+
+	globals := make(map[string]any)
+	vm.File = path
+	vm.Call(&Closure{Function: function, Globals: globals}, nil)
+	return globals
+}
+
+// run executes instructions until the frame count drops back to exitDepth, leaving the last return value on the
+// stack. Errors caught by a try block in one of those frames carry on from its handler.
+func (vm *VM) run(exitDepth int) {
+	for vm.execute(exitDepth) {
+	}
+}
+
+// execute is run's main loop. It returns true when it stopped because an error was caught, which means it has to be
+// started again to continue from the handler.
+func (vm *VM) execute(exitDepth int) (caught bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			vm.catch(r, exitDepth)
+			caught = true
+		}
+	}()
+
+	frame := &vm.frames[vm.frameCount-1]
+	code := frame.closure.Function.Chunk.Code
+
+	readByte := func() byte {
+		frame.ip++
+		return code[frame.ip-1]
+	}
+	readShort := func() int {
+		frame.ip += 2
+		return int(code[frame.ip-2])<<8 | int(code[frame.ip-1])
+	}
+	readConstant := func() any {
+		return frame.closure.Function.Chunk.Constants[readShort()]
+	}
+	readString := func() string {
+		return readConstant().(string)
+	}
+	// Calls and returns switch frames, so the cached frame and code have to follow
+	refreshFrame := func() {
+		frame = &vm.frames[vm.frameCount-1]
+		code = frame.closure.Function.Chunk.Code
+	}
+
+	for {
+		switch compiler.OpCode(readByte()) {
+		case compiler.OP_CONSTANT:
+			vm.push(readConstant())
+		case compiler.OP_NIL:
+			vm.push(nil)
+		case compiler.OP_TRUE:
+			vm.push(true)
+		case compiler.OP_FALSE:
+			vm.push(false)
+		case compiler.OP_POP:
+			vm.pop()
+
+		case compiler.OP_GET_LOCAL:
+			vm.push(vm.stack[frame.slots+int(readByte())])
+		case compiler.OP_SET_LOCAL:
+			vm.stack[frame.slots+int(readByte())] = vm.peek(0)
+		case compiler.OP_GET_GLOBAL:
+			name := readString()
+			value, ok := frame.closure.Globals[name]
+			if !ok {
+				value, ok = vm.natives[name]
+			}
+			if !ok {
+				vm.runtimeError(errors.UNDEFINED_VARIABLE, "Undefined variable '"+name+"'")
+			}
+			vm.push(value)
+		case compiler.OP_DEFINE_GLOBAL:
+			frame.closure.Globals[readString()] = vm.pop()
+		case compiler.OP_SET_GLOBAL:
+			name := readString()
+			_, ok := frame.closure.Globals[name]
+			if !ok {
+				_, ok = vm.natives[name]
+			}
+			if !ok {
+				vm.runtimeError(errors.UNDEFINED_VARIABLE, "Undefined variable '"+name+"'")
+			}
+			frame.closure.Globals[name] = vm.peek(0)
+		case compiler.OP_GET_UPVALUE:
+			vm.push(*frame.closure.Upvalues[readByte()].Location)
+		case compiler.OP_SET_UPVALUE:
+			*frame.closure.Upvalues[readByte()].Location = vm.peek(0)
+		case compiler.OP_GET_PROPERTY:
+			if module, ok := vm.peek(0).(*builtins.Module); ok {
+				value := module.Export(readString())
+				vm.pop()
+				vm.push(value)
+				break
+			}
+			if exception, ok := vm.peek(0).(*builtins.Exception); ok {
+				value := exception.Field(readString())
+				vm.pop()
+				vm.push(value)
+				break
+			}
+
+			instance, ok := vm.peek(0).(*Instance)
+			if !ok {
+				vm.runtimeError(errors.TYPE_ERROR, "only instances have properties")
+			}
+
+			name := readString()
+			if value, ok := instance.Fields[name]; ok {
+				vm.pop()
+				vm.push(value)
+				break
+			}
+			vm.bindMethod(instance.Class, name)
+		case compiler.OP_SET_PROPERTY:
+			instance, ok := vm.peek(1).(*Instance)
+			if !ok {
+				vm.runtimeError(errors.TYPE_ERROR, "only instances have fields")
+			}
+
+			value := vm.pop()
+			name := readString()
+			// A new field costs as much as a new map entry
+			if _, ok := instance.Fields[name]; !ok {
+				vm.Allocate(2 * builtins.VALUE_SIZE)
+			}
+			instance.Fields[name] = value
+			vm.pop()
+			vm.push(value)
+		case compiler.OP_GET_SUPER:
+			superclass := vm.pop().(*Class)
+			vm.bindMethod(superclass, readString())
+
+		case compiler.OP_EQUAL:
+			b, a := vm.pop(), vm.pop()
+			vm.push(isEqual(a, b))
+		case compiler.OP_NOT_EQUAL:
+			b, a := vm.pop(), vm.pop()
+			vm.push(!isEqual(a, b))
+		case compiler.OP_GREATER:
+			a, b := vm.popNumbers()
+			vm.push(a > b)
+		case compiler.OP_GREATER_EQUAL:
+			a, b := vm.popNumbers()
+			vm.push(a >= b)
+		case compiler.OP_LESS:
+			a, b := vm.popNumbers()
+			vm.push(a < b)
+		case compiler.OP_LESS_EQUAL:
+			a, b := vm.popNumbers()
+			vm.push(a <= b)
+		case compiler.OP_ADD:
+			b, a := vm.pop(), vm.pop()
+			if af, ok := a.(float64); ok {
+				if bf, ok := b.(float64); ok {
+					vm.push(af + bf)
+					break
+				}
+			}
+			if as, ok := a.(string); ok {
+				if bs, ok := b.(string); ok {
+					vm.Allocate(len(as) + len(bs))
+					vm.push(as + bs)
+					break
+				}
+			}
+			vm.runtimeError(errors.TYPE_ERROR, "operands must be either two numbers or two strings")
+		case compiler.OP_SUBTRACT:
+			a, b := vm.popNumbers()
+			vm.push(a - b)
+		case compiler.OP_MULTIPLY:
+			a, b := vm.popNumbers()
+			vm.push(a * b)
+		case compiler.OP_DIVIDE:
+			a, b := vm.popNumbers()
+			vm.push(a / b)
+		case compiler.OP_MODULO:
+			a, b := vm.popNumbers()
+			vm.push(math.Mod(a, b))
+		case compiler.OP_POWER:
+			a, b := vm.popNumbers()
+			vm.push(math.Pow(a, b))
+		case compiler.OP_NOT:
+			vm.push(!isTruthy(vm.pop()))
+		case compiler.OP_NEGATE:
+			vm.push(-vm.popNumber())
+		case compiler.OP_INCREMENT:
+			vm.push(vm.popNumber() + 1)
+		case compiler.OP_DECREMENT:
+			vm.push(vm.popNumber() - 1)
+
+		case compiler.OP_PRINT:
+			fmt.Fprintln(vm.Stdout, stringify(vm.pop()))
+		case compiler.OP_JUMP:
+			offset := readShort()
+			frame.ip += offset
+		case compiler.OP_JUMP_IF_FALSE:
+			offset := readShort()
+			if !isTruthy(vm.peek(0)) {
+				frame.ip += offset
+			}
+		case compiler.OP_LOOP:
+			offset := readShort()
+			vm.step()
+			frame.ip -= offset
+		case compiler.OP_CALL:
+			argCount := int(readByte())
+			vm.callValue(vm.peek(argCount), argCount)
+			refreshFrame()
+		case compiler.OP_CLOSURE:
+			function := readConstant().(*compiler.Function)
+			closure := &Closure{Function: function, Upvalues: make([]*Upvalue, function.UpvalueCount), Globals: frame.closure.Globals}
+			for i := range closure.Upvalues {
+				isLocal := readByte()
+				index := int(readByte())
+				if isLocal == 1 {
+					closure.Upvalues[i] = vm.captureUpvalue(frame.slots + index)
+				} else {
+					closure.Upvalues[i] = frame.closure.Upvalues[index]
+				}
+			}
+			vm.push(closure)
+		case compiler.OP_CLOSE_UPVALUE:
+			vm.closeUpvalues(vm.stackTop - 1)
+			vm.pop()
+		case compiler.OP_RETURN:
+			result := vm.pop()
+			vm.closeUpvalues(frame.slots)
+			vm.frameCount--
+
+			vm.stackTop = frame.slots
+			vm.push(result)
+			if vm.frameCount == exitDepth {
+				return false
+			}
+			refreshFrame()
+
+		case compiler.OP_CLASS:
+			vm.push(&Class{Name: readString(), Methods: make(map[string]*Closure)})
+		case compiler.OP_INHERIT:
+			superclass, ok := vm.peek(1).(*Class)
+			if !ok {
+				vm.runtimeError(errors.TYPE_ERROR, "superclass must be a class")
+			}
+
+			// Methods are copied down when the class is created, so lookups never need to walk the hierarchy
+			subclass := vm.peek(0).(*Class)
+			for name, method := range superclass.Methods {
+				subclass.Methods[name] = method
+			}
+			vm.pop()
+		case compiler.OP_METHOD:
+			method := vm.peek(0).(*Closure)
+			class := vm.peek(1).(*Class)
+			class.Methods[readString()] = method
+			vm.pop()
+
+		case compiler.OP_INTERPOLATE:
+			count := readShort()
+			var text strings.Builder
+			for _, part := range vm.stack[vm.stackTop-count : vm.stackTop] {
+				text.WriteString(stringify(part))
+			}
+			vm.Allocate(text.Len())
+			vm.stackTop -= count
+			vm.push(text.String())
+
+		case compiler.OP_BUILD_LIST:
+			count := readShort()
+			vm.Allocate(builtins.COLLECTION_SIZE + count*builtins.VALUE_SIZE)
+			elements := make([]any, count)
+			copy(elements, vm.stack[vm.stackTop-count:vm.stackTop])
+			vm.stackTop -= count
+			vm.push(builtins.NewList(elements))
+		case compiler.OP_BUILD_MAP:
+			count := readShort()
+			vm.Allocate(builtins.COLLECTION_SIZE + count*2*builtins.VALUE_SIZE)
+			m := builtins.NewMap()
+			entries := vm.stack[vm.stackTop-count*2 : vm.stackTop]
+			for i := 0; i < len(entries); i += 2 {
+				m.Set(entries[i], entries[i+1])
+			}
+			vm.stackTop -= count * 2
+			vm.push(m)
+		case compiler.OP_IMPORT:
+			vm.push(vm.modules.Import(vm.File, readString(), vm.runModule))
+		case compiler.OP_IMPORT_NAME:
+			module := vm.peek(0).(*builtins.Module)
+			vm.push(module.Export(readString()))
+
+		case compiler.OP_TRY:
+			offset := readShort()
+			vm.handlers = append(vm.handlers, handler{frameCount: vm.frameCount, stackTop: vm.stackTop, ip: frame.ip + offset})
+		case compiler.OP_END_TRY:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+		case compiler.OP_CATCH:
+			vm.push(builtins.Caught(vm.pop().(errors.RuntimeError)))
+		case compiler.OP_THROW:
+			panic(builtins.Thrown(vm.currentToken(), vm.pop()))
+		case compiler.OP_RETHROW:
+			panic(vm.pop().(errors.RuntimeError))
+
+		case compiler.OP_ITERATE:
+			single := readByte() == 1
+			vm.push(builtins.Iterate(vm.pop(), single))
+		case compiler.OP_FOR_ITER:
+			count := readByte()
+			offset := readShort()
+			key, value, ok := vm.peek(0).(builtins.Iterator).Next()
+			if !ok {
+				frame.ip += offset
+				break
+			}
+			if count == 2 {
+				vm.push(key)
+			}
+			vm.push(value)
+		case compiler.OP_GET_INDEX:
+			index, object := vm.pop(), vm.pop()
+			vm.push(builtins.Index(object, index))
+		case compiler.OP_SET_INDEX:
+			value, index, object := vm.pop(), vm.pop(), vm.pop()
+			builtins.SetIndex(vm, object, index, value)
+			vm.push(value)
+		case compiler.OP_SLICE:
+			end, start, object := vm.pop(), vm.pop(), vm.pop()
+			vm.push(builtins.Slice(vm, object, start, end))
+		}
+	}
+}
+
+// catch unwinds to the innermost open try block and leaves the error on the stack for its handler. Errors that no
+// try block in this run's frames can catch (and anything that isn't a runtime error) keep unwinding.
+func (vm *VM) catch(r any, exitDepth int) {
+	if e, ok := r.(builtins.Error); ok {
+		r = errors.RuntimeError{Token: vm.currentToken(), Code: e.Code, Message: e.Message}
+	}
+	e, ok := r.(errors.RuntimeError)
+	if !ok {
+		panic(r)
+	}
+
+	e = vm.withStack(e)
+	if len(vm.handlers) == 0 || vm.handlers[len(vm.handlers)-1].frameCount <= exitDepth {
+		panic(e)
+	}
+
+	handler := vm.handlers[len(vm.handlers)-1]
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+	vm.closeUpvalues(handler.stackTop)
+	vm.frameCount = handler.frameCount
+	vm.stackTop = handler.stackTop
+	vm.frames[vm.frameCount-1].ip = handler.ip
+	vm.push(e)
+}
+
+// withStack records the call stack on an error that doesn't have one yet.
+func (vm *VM) withStack(e errors.RuntimeError) errors.RuntimeError {
+	if e.Stack != nil {
+		return e
+	}
+
+	e.Stack = make([]errors.StackFrame, vm.frameCount)
+	for i := 0; i < vm.frameCount; i++ {
+		frame := &vm.frames[i]
+		name := frame.closure.Function.Name
+		if name == "" {
+			name = "<script>"
+		}
+		line := 0
+		if frame.ip > 0 {
+			line = frame.closure.Function.Chunk.Token(frame.ip - 1).Line
+		}
+		e.Stack[i] = errors.StackFrame{Function: name, Line: line, File: frame.closure.Function.File}
+	}
+	return e
+}
+
+func (vm *VM) callValue(callee any, argCount int) {
+	vm.step()
+	switch callee := callee.(type) {
+	case *Closure:
+		vm.call(callee, argCount)
+		return
+	case *builtins.Native:
+		vm.checkArity(callee.ArityNumber, argCount)
+		arguments := make([]any, argCount)
+		copy(arguments, vm.stack[vm.stackTop-argCount:vm.stackTop])
+		result := callee.NativeLogic(vm, arguments)
+		vm.stackTop -= argCount + 1
+		vm.push(result)
+		return
+	case *Class:
+		vm.Allocate(builtins.COLLECTION_SIZE)
+		vm.stack[vm.stackTop-argCount-1] = &Instance{Class: callee, Fields: make(map[string]any)}
+		if initializer, ok := callee.Methods["init"]; ok {
+			vm.call(initializer, argCount)
+		} else {
+			vm.checkArity(0, argCount)
+		}
+		return
+	case *BoundMethod:
+		vm.stack[vm.stackTop-argCount-1] = callee.Receiver
+		vm.call(callee.Method, argCount)
+		return
+	}
+
+	vm.runtimeError(errors.TYPE_ERROR, "can only call functions and classes")
+}
+
+// step counts a loop iteration or call against the budget, stopping the script if it's over.
+func (vm *VM) step() {
+	vm.overBudget(vm.budget.Step())
+}
+
+// Allocate counts the memory for a value about to be made, stopping the script if it's over the limit.
+func (vm *VM) Allocate(bytes int) {
+	vm.overBudget(vm.budget.Allocate(bytes))
+}
+
+func (vm *VM) overBudget(exceeded *errors.LimitError) {
+	if exceeded != nil {
+		e := *exceeded
+		e.Token = vm.currentToken()
+		panic(e)
+	}
+}
+
+func (vm *VM) call(closure *Closure, argCount int) {
+	vm.checkArity(closure.Function.Arity, argCount)
+
+	if vm.frameCount == len(vm.frames) {
+		panic(builtins.DepthError(len(vm.frames)))
+	}
+
+	frame := &vm.frames[vm.frameCount]
+	frame.closure = closure
+	frame.ip = 0
+	frame.slots = vm.stackTop - argCount - 1
+	vm.frameCount++
+}
+
+func (vm *VM) checkArity(arity, argCount int) {
+	if arity != builtins.VARIADIC && argCount != arity {
+		vm.runtimeError(errors.WRONG_ARITY, "expected "+fmt.Sprint(arity)+" arguments but got "+fmt.Sprint(argCount))
+	}
+}
+
+func (vm *VM) bindMethod(class *Class, name string) {
+	method, ok := class.Methods[name]
+	if !ok {
+		vm.runtimeError(errors.UNDEFINED_PROPERTY, "undefined property '"+name+"'")
+	}
+
+	bound := &BoundMethod{Receiver: vm.peek(0), Method: method}
+	vm.pop()
+	vm.push(bound)
+}
+
+func (vm *VM) captureUpvalue(slot int) *Upvalue {
+	var previous *Upvalue
+	upvalue := vm.openUpvalues
+	for upvalue != nil && upvalue.slot > slot {
+		previous = upvalue
+		upvalue = upvalue.next
+	}
+
+	if upvalue != nil && upvalue.slot == slot {
+		return upvalue
+	}
+
+	created := &Upvalue{Location: &vm.stack[slot], slot: slot, next: upvalue}
+	if previous == nil {
+		vm.openUpvalues = created
+	} else {
+		previous.next = created
+	}
+	return created
+}
+
+func (vm *VM) closeUpvalues(last int) {
+	for vm.openUpvalues != nil && vm.openUpvalues.slot >= last {
+		upvalue := vm.openUpvalues
+		upvalue.Closed = *upvalue.Location
+		upvalue.Location = &upvalue.Closed
+		vm.openUpvalues = upvalue.next
+	}
+}
+
+func (vm *VM) push(value any) {
+	if vm.stackTop == len(vm.stack) {
+		vm.growStack()
+	}
+	vm.stack[vm.stackTop] = value
+	vm.stackTop++
+}
+
+// growStack doubles the stack, moving the open upvalues along with the slots they point at.
+func (vm *VM) growStack() {
+	stack := make([]any, len(vm.stack)*2)
+	copy(stack, vm.stack)
+	vm.stack = stack
+	for upvalue := vm.openUpvalues; upvalue != nil; upvalue = upvalue.next {
+		upvalue.Location = &vm.stack[upvalue.slot]
+	}
+}
+
+func (vm *VM) pop() any {
+	vm.stackTop--
+	return vm.stack[vm.stackTop]
+}
+
+func (vm *VM) peek(distance int) any {
+	return vm.stack[vm.stackTop-1-distance]
+}
+
+func (vm *VM) popNumber() float64 {
+	number, ok := vm.peek(0).(float64)
+	if !ok {
+		vm.runtimeError(errors.TYPE_ERROR, "operand must be a number")
+	}
+	vm.pop()
+	return number
+}
+
+func (vm *VM) popNumbers() (float64, float64) {
+	b, bok := vm.peek(0).(float64)
+	a, aok := vm.peek(1).(float64)
+	if !aok || !bok {
+		vm.runtimeError(errors.TYPE_ERROR, "operands must be numbers")
+	}
+	vm.stackTop -= 2
+	return a, b
+}
+
+func (vm *VM) resetStack() {
+	for i := 0; i < vm.stackTop; i++ {
+		vm.stack[i] = nil
+	}
+	vm.stackTop = 0
+	vm.frameCount = 0
+	vm.openUpvalues = nil
+	vm.handlers = nil
+}
+
+func (vm *VM) runtimeError(code errors.Code, message string) {
+	panic(errors.RuntimeError{Token: vm.currentToken(), Code: code, Message: message})
+}
+
+// currentToken is the source token of the instruction being executed.
+func (vm *VM) currentToken() ast.Token {
+	frame := &vm.frames[vm.frameCount-1]
+	return frame.closure.Function.Chunk.Token(frame.ip - 1)
+}
+
+func isTruthy(value any) bool {
+	return builtins.IsTruthy(value)
+}
+
+func isEqual(a, b any) bool {
+	return builtins.Equal(a, b)
+}
+
+func stringify(value any) string {
+	return builtins.Stringify(value)
+}